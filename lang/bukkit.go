@@ -0,0 +1,240 @@
+package lang
+
+// Bukkit is a BUKKIT: an object whose named slots hold values, or functions called
+// on it with IZ.  A BUKKIT made LIEK A another inherits the slots of that parent,
+// until it gives them values of its own.
+type Bukkit struct {
+	slots  map[string]interface{}
+	names  []string // of the slots, in the order they were made
+	parent *Bukkit
+}
+
+// For WithMaxAlloc, roughly the memory a slot takes besides its name, and a BUKKIT besides its slots
+const (
+	slotSize   = 32
+	bukkitSize = 64
+)
+
+// NewBukkit makes an empty BUKKIT
+func NewBukkit() *Bukkit {
+	return &Bukkit{slots: make(map[string]interface{})}
+}
+
+// Get returns the value of a slot, which may be inherited from the parent, as
+// Interpreter.Get would.  A slot holding a function, to be called with IZ, isn't given.
+func (b *Bukkit) Get(name string) (Value, bool) {
+	return toGo(b.get(name))
+}
+
+// get finds a slot, which may be inherited from the parent
+func (b *Bukkit) get(name string) (interface{}, bool) {
+	for ; b != nil; b = b.parent {
+		if val, ok := b.slots[name]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// Set makes a slot of the BUKKIT itself, or changes its value.
+// val may be of any type Interpreter.Set accepts.
+func (b *Bukkit) Set(name string, val interface{}) error {
+	v, err := fromGo(val)
+	if err != nil {
+		return err
+	}
+	if _, ok := b.slots[name]; !ok {
+		b.names = append(b.names, name)
+	}
+	b.slots[name] = v
+	return nil
+}
+
+// Slots lists the names of the BUKKIT's own slots, in the order they were made
+func (b *Bukkit) Slots() []string {
+	return append([]string(nil), b.names...)
+}
+
+// liek makes a BUKKIT that inherits from parent
+func liek(parent interface{}) interface{} {
+	b := NewBukkit()
+	b.parent = asBukkit(parent)
+	return b
+}
+
+func asBukkit(x interface{}) *Bukkit {
+	b, ok := x.(*Bukkit)
+	if !ok {
+		raise(BadType, "Cannot use slots of type %s", typeName(x))
+	}
+	return b
+}
+
+// srsName casts a name of a variable, function or slot, which SRS may have given
+// as any value, to a YARN
+func srsName(name interface{}) string {
+	if s, ok := name.(string); ok {
+		return s
+	}
+	return yarn(name, false)
+}
+
+func getSlot(obj, name interface{}) interface{} {
+	n := srsName(name)
+	val, ok := asBukkit(obj).get(n)
+	if !ok {
+		raise(UndefinedSlot, "Reference to undefined slot: %s", n)
+	}
+	return val
+}
+
+// setSlot assigns to a slot, which must exist unless it's being declared with HAS A.
+// Assigning to an inherited slot gives obj a slot of its own.
+func (r *run) setSlot(obj, name, val interface{}, declare bool) {
+	b, n := asBukkit(obj), srsName(name)
+	if _, ok := b.slots[n]; !ok {
+		if _, inherited := b.get(n); !inherited && !declare {
+			raise(UndefinedSlot, "Assignment to undefined slot: %s", n)
+		}
+		r.alloc(len(n) + slotSize)
+		b.names = append(b.names, n)
+	}
+	b.slots[n] = val
+}
+
+// method finds the function in slot name of obj
+func method(obj, name interface{}) *function {
+	f, ok := getSlot(obj, name).(*function)
+	if !ok {
+		raise(BadType, "Slot %s is not a function", srsName(name))
+	}
+	return f
+}
+
+// A place is what a statement starting with an identifier works on: a variable,
+// or a slot of a BUKKIT reached from it through the names of slots
+type place struct {
+	name  varName
+	slots []expr
+}
+
+func newPlace(name, slots interface{}) place {
+	p := place{name: name.(varName)}
+	for _, s := range slots.([]interface{}) {
+		p.slots = append(p.slots, s.(expr))
+	}
+	return p
+}
+
+func (p place) get(ns *namespace) interface{} {
+	val := ns.getOrPanic(p.name.get(ns))
+	for _, s := range p.slots {
+		val = getSlot(val, s(ns))
+	}
+	return val
+}
+
+// resolve finds the BUKKIT and the name of the slot that a place with slots is
+func (p place) resolve(ns *namespace) (obj, name interface{}) {
+	last := len(p.slots) - 1
+	obj = place{p.name, p.slots[:last]}.get(ns)
+	return obj, p.slots[last](ns)
+}
+
+// put assigns the value of e to the place, after working out where the place is
+func (p place) put(ns *namespace, e expr) {
+	if len(p.slots) == 0 {
+		ident := p.name.get(ns)
+		ns.putOrPanic(ident, e(ns))
+		return
+	}
+	obj, name := p.resolve(ns)
+	ns.frame.run.setSlot(obj, name, e(ns), false)
+}
+
+// update replaces the value of the place with f of its value
+func (p place) update(ns *namespace, f func(interface{}) interface{}) {
+	if len(p.slots) == 0 {
+		ident := p.name.get(ns)
+		ns.putOrPanic(ident, f(ns.getOrPanic(ident)))
+		return
+	}
+	obj, name := p.resolve(ns)
+	ns.frame.run.setSlot(obj, name, f(getSlot(obj, name)), false)
+}
+
+// A methodCall is IZ and what follows: the slot of the function and its arguments
+type methodCall struct {
+	name expr
+	args []expr
+}
+
+func izCall(args []interface{}) interface{} {
+	c := methodCall{name: args[1].(expr)}
+	if args[2] != nil {
+		for _, a := range args[2].([]interface{}) {
+			c.args = append(c.args, a.(expr))
+		}
+	}
+	return c
+}
+
+// call calls the method on obj
+func (c methodCall) call(obj interface{}, ns *namespace) interface{} {
+	name := c.name(ns)
+	vals := make([]interface{}, len(c.args))
+	for i, a := range c.args {
+		vals[i] = a(ns)
+	}
+	return method(obj, name).call(obj, vals, ns.frame.run)
+}
+
+func izPredicate(args []interface{}) interface{} {
+	c := args[0].(methodCall)
+	return pred(func(p place, ns *namespace) {
+		ns.setIT(c.call(p.get(ns), ns))
+	})
+}
+
+func hasaSlot(args []interface{}) interface{} {
+	name := args[1].(expr)
+	val := literal([]interface{}{nil}).(expr)
+	if args[2] != nil { // ITZ is optional
+		val = args[2].(expr)
+	}
+	return pred(func(p place, ns *namespace) {
+		obj, n := p.get(ns), name(ns)
+		ns.frame.run.setSlot(obj, n, val(ns), true)
+	})
+}
+
+// itzAtype is ITZ A followed by a type, which gives the value NOOB cast to it
+func itzAtype(args []interface{}) interface{} {
+	cast := castFunc(args[1].(string))
+	return expr(func(ns *namespace) interface{} {
+		return ns.frame.run.allocCast(nil, cast(nil))
+	})
+}
+
+func itzLiekA(args []interface{}) interface{} {
+	parent := args[1].(expr)
+	return expr(func(ns *namespace) interface{} {
+		ns.frame.run.alloc(bukkitSize)
+		return liek(parent(ns))
+	})
+}
+
+// HOW IZ puts a function in a slot of a BUKKIT, to be called on it with IZ
+func howizObj(args []interface{}) interface{} {
+	obj := newPlace(args[1], args[2])
+	f := &function{name: args[3].(string), body: block(args[6]), method: true}
+	params, err := paramList(f.name, args[4])
+	if err != nil {
+		return err
+	}
+	f.params, f.nargs = params, len(params)
+	return statement(func(ns *namespace) flow {
+		ns.frame.run.setSlot(obj.get(ns), f.name, f.definedIn(ns.frame), true)
+		return flowNext
+	})
+}
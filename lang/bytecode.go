@@ -0,0 +1,703 @@
+package lang
+
+import (
+	"lol/token"
+	"lol/vm"
+	"strings"
+)
+
+// The bytecode backend compiles each statement and expression to a run of
+// instructions, and assembles them into a *vm.Func for each function and for the
+// top level.  A vmHost gives the machine the semantics the closures have.
+
+// code is a run of instructions not yet assembled.  An instruction that refers to a
+// constant, name, position or function holds it in arg until assemble puts it in a
+// table of the vm.Func.  Jumps are relative, so runs of code can simply be joined.
+type code []ins
+
+type ins struct {
+	vm.Instr
+	arg interface{}
+}
+
+func op(o vm.Op, arg interface{}) ins {
+	return ins{Instr: vm.Instr{Op: o}, arg: arg}
+}
+
+// jump makes an instruction that jumps offset instructions past itself
+func jump(o vm.Op, offset int) ins {
+	return ins{Instr: vm.Instr{Op: o, A: int32(offset)}}
+}
+
+func join(runs ...code) code {
+	var c code
+	for _, r := range runs {
+		c = append(c, r...)
+	}
+	return c
+}
+
+func blockCode(arg interface{}) code {
+	var c code
+	for _, s := range arg.([]interface{}) {
+		c = append(c, s.(code)...)
+	}
+	return c
+}
+
+// breakTo turns the GTFOs left in c, those not inside a loop or switch of their own,
+// into jumps to the instruction at exit
+func (c code) breakTo(exit int) {
+	for i := range c {
+		if c[i].Op == vm.OpGTFO {
+			c[i] = jump(vm.OpJump, exit-i-1)
+		}
+	}
+}
+
+// assemble builds the vm.Func for a function, or the top level if name is ""
+func assemble(name string, params []string, c code) *vm.Func {
+	f := &vm.Func{Name: name, Params: params, Code: make([]vm.Instr, len(c))}
+	consts := make(map[interface{}]int32)
+	names := make(map[string]int32)
+	for i, in := range c {
+		switch in.Op {
+		case vm.OpConst, vm.OpCase, vm.OpCanHas:
+			idx, ok := consts[in.arg]
+			if !ok {
+				idx = int32(len(f.Consts))
+				consts[in.arg] = idx
+				f.Consts = append(f.Consts, vm.ValueOf(in.arg))
+			}
+			in.A = idx
+		case vm.OpLoad, vm.OpStore, vm.OpDeclare, vm.OpCast, vm.OpCall:
+			idx, ok := names[in.arg.(string)]
+			if !ok {
+				idx = int32(len(f.Names))
+				names[in.arg.(string)] = idx
+				f.Names = append(f.Names, in.arg.(string))
+			}
+			in.A = idx
+		case vm.OpPos:
+			in.A = int32(len(f.Pos))
+			f.Pos = append(f.Pos, in.arg.(token.Pos))
+		case vm.OpDefine, vm.OpFunc:
+			in.A = int32(len(f.Funcs))
+			f.Funcs = append(f.Funcs, in.arg.(*vm.Func))
+		}
+		f.Code[i] = in.Instr
+	}
+	return f
+}
+
+var bytecode = actions{
+	haiBlock: func(args []interface{}) interface{} {
+		return &Program{code: assemble("", nil, blockCode(args[3]))}
+	},
+	atPos: func(pos token.Pos, args []interface{}) interface{} {
+		return join(code{op(vm.OpPos, pos)}, args[0].(code))
+	},
+	varPredicate: func(args []interface{}) interface{} {
+		return args[2].(bytecodePred)(newBytecodePlace(args[0], args[1]))
+	},
+	ihasaVarItz: func(args []interface{}) interface{} {
+		val := code{op(vm.OpConst, nil)}
+		if args[2] != nil { // ITZ is optional
+			val = args[2].(code)
+		}
+		return args[1].(bytecodeVar).declare(val)
+	},
+	visible: func(args []interface{}) interface{} {
+		exprs := args[1].([]code)
+		visible := op(vm.OpVisible, nil)
+		visible.A = int32(len(exprs))
+		if args[2] == nil { // ! suppresses the newline
+			visible.B = 1
+		}
+		return append(join(exprs...), visible)
+	},
+	gimmeh: func(args []interface{}) interface{} {
+		return args[1].(bytecodeVar).store(code{op(vm.OpGimmeh, nil)})
+	},
+	// LOAD IT; JUMPIFFALSE; YA RLY; JUMP end; then each MEBBE's condition,
+	// JUMPIFFALSE, body and JUMP end in turn; then NO WAI.
+	orly: func(args []interface{}) interface{} {
+		var rest code
+		if args[6] != nil {
+			rest = args[6].(code)
+		}
+		mebbes := args[5].([]interface{})
+		for i := len(mebbes) - 1; i >= 0; i-- {
+			m := mebbes[i].(bytecodeClause)
+			rest = join(m.cond, code{jump(vm.OpJumpIfFalse, len(m.body)+1)}, m.body,
+				code{jump(vm.OpJump, len(rest))}, rest)
+		}
+		yarly := blockCode(args[4])
+		return join(code{op(vm.OpLoad, "IT"), jump(vm.OpJumpIfFalse, len(yarly)+1)}, yarly,
+			code{jump(vm.OpJump, len(rest))}, rest)
+	},
+	mebbe: func(args []interface{}) interface{} {
+		return bytecodeClause{args[1].(code), blockCode(args[3])}
+	},
+	noWai: func(args []interface{}) interface{} {
+		return blockCode(args[2])
+	},
+	// LOAD IT; a CASE for each OMG; POP; JUMP to OMGWTF; then the bodies of the
+	// OMGs and OMGWTF, one after another so that they fall through.
+	wtf: func(args []interface{}) interface{} {
+		omgs := args[2].([]interface{})
+		var labels []interface{}
+		var bodies code
+		cases := code{op(vm.OpLoad, "IT")}
+		for i, o := range omgs {
+			o := o.(bytecodeOmg)
+			if err := checkLabel(labels, o.label, o.pos); err != nil {
+				return err
+			}
+			labels = append(labels, o.label)
+			c := op(vm.OpCase, o.label)
+			c.B = int32(len(omgs) - i + 1 + len(bodies)) // past the rest of the cases, POP and JUMP
+			cases = append(cases, c)
+			bodies = append(bodies, o.body...)
+		}
+		cases = append(cases, op(vm.OpPop, nil), jump(vm.OpJump, len(bodies)))
+		if args[3] != nil {
+			bodies = append(bodies, args[3].(code)...)
+		}
+		c := join(cases, bodies)
+		c.breakTo(len(c))
+		return c
+	},
+	omgLabel: func(pos token.Pos, args []interface{}) interface{} {
+		return bytecodeOmg{args[1], blockCode(args[3]), pos}
+	},
+	omgwtf: func(args []interface{}) interface{} {
+		return blockCode(args[2])
+	},
+	gtfo: func(args []interface{}) interface{} {
+		return code{op(vm.OpGTFO, nil)}
+	},
+	// ENTERSCOPE; declare the variable; then each time round, the condition,
+	// JUMPIFFALSE to the end, STEP, the body, and the variable's update;
+	// then EXITSCOPE.
+	loop: func(args []interface{}) interface{} {
+		if err := checkLoopLabels(args); err != nil {
+			return err
+		}
+		c := code{op(vm.OpEnterScope, nil)}
+		lop, hasOp := args[2].(loopOp)
+		if hasOp {
+			c = append(c, op(vm.OpConst, int64(0)), op(vm.OpDeclare, lop.ident))
+		}
+		top := len(c)
+		body := join(code{op(vm.OpStep, nil)}, blockCode(args[5]))
+		if hasOp {
+			body = append(body, op(vm.OpLoad, lop.ident), op(vm.OpConst, lop.step),
+				op(vm.OpAdd, nil), op(vm.OpStore, lop.ident))
+		}
+		if cond, ok := args[3].(code); ok {
+			c = append(c, cond...)
+			c = append(c, jump(vm.OpJumpIfFalse, len(body)+1))
+		}
+		c = append(c, body...)
+		c = append(c, jump(vm.OpJump, top-len(c)-1), op(vm.OpExitScope, nil))
+		c.breakTo(len(c) - 1)
+		return c
+	},
+	tilExpr: func(args []interface{}) interface{} {
+		return append(join(args[1].(code)), op(vm.OpNot, nil))
+	},
+	wileExpr: func(args []interface{}) interface{} {
+		return args[1]
+	},
+	howizI: func(args []interface{}) interface{} {
+		name := args[1].(string)
+		params, err := paramList(name, args[2])
+		if err != nil {
+			return err
+		}
+		return code{op(vm.OpDefine, funcCode(name, params, args[4]))}
+	},
+	// A function in a slot takes ME as its first argument
+	howizObj: func(args []interface{}) interface{} {
+		name := args[3].(string)
+		params, err := paramList(name, args[4])
+		if err != nil {
+			return err
+		}
+		f := funcCode(name, append([]string{"ME"}, params...), args[6])
+		return append(newBytecodePlace(args[1], args[2]).get(),
+			op(vm.OpConst, name), op(vm.OpFunc, f), count(vm.OpSetSlot, 1))
+	},
+	foundYr: func(args []interface{}) interface{} {
+		return append(join(args[1].(code)), op(vm.OpReturn, nil))
+	},
+	bareExpr: func(args []interface{}) interface{} {
+		return append(join(args[0].(code)), op(vm.OpStore, "IT"))
+	},
+
+	emptyPredicate: func(args []interface{}) interface{} {
+		return bytecodePred(func(p bytecodePlace) code {
+			return append(p.get(), op(vm.OpStore, "IT"))
+		})
+	},
+	rExpr: func(args []interface{}) interface{} {
+		return bytecodePred(func(p bytecodePlace) code {
+			if len(p.slots) == 0 {
+				return p.name.store(args[1].(code))
+			}
+			return append(join(p.resolve(), args[1].(code)), op(vm.OpSetSlot, nil))
+		})
+	},
+	isnowAtype: func(args []interface{}) interface{} {
+		return bytecodePred(func(p bytecodePlace) code {
+			if len(p.slots) == 0 {
+				return p.name.update(code{op(vm.OpCast, args[1])})
+			}
+			return append(p.resolve(), count(vm.OpDup, 2), op(vm.OpSlot, nil), op(vm.OpCast, args[1]),
+				op(vm.OpSetSlot, nil))
+		})
+	},
+	hasaSlot: func(args []interface{}) interface{} {
+		val := code{op(vm.OpConst, nil)}
+		if args[2] != nil { // ITZ is optional
+			val = args[2].(code)
+		}
+		return bytecodePred(func(p bytecodePlace) code {
+			return append(join(p.get(), args[1].(code), val), count(vm.OpSetSlot, 1))
+		})
+	},
+	izPredicate: func(args []interface{}) interface{} {
+		return bytecodePred(func(p bytecodePlace) code {
+			return append(join(p.get(), args[0].(code)), op(vm.OpStore, "IT"))
+		})
+	},
+	// IZ calls the method on the BUKKIT left on the stack
+	izCall: func(args []interface{}) interface{} {
+		c := join(args[1].(code))
+		var nargs int
+		if args[2] != nil {
+			for _, a := range args[2].([]interface{}) {
+				c = append(c, a.(code)...)
+				nargs++
+			}
+		}
+		return append(c, count(vm.OpMethod, nargs))
+	},
+	itzAtype: func(args []interface{}) interface{} {
+		return code{op(vm.OpConst, nil), op(vm.OpCast, args[1])}
+	},
+	itzLiekA: func(args []interface{}) interface{} {
+		return append(join(args[1].(code)), op(vm.OpLiek, nil))
+	},
+
+	exprMoar: func(args []interface{}) interface{} {
+		list := []code{args[0].(code)}
+		for _, e := range args[1].([]interface{}) {
+			list = append(list, e.(code))
+		}
+		return list
+	},
+	literal: func(args []interface{}) interface{} {
+		return code{op(vm.OpConst, args[0])}
+	},
+	noob: func(args []interface{}) interface{} {
+		if err := checkNoob(args[0]); err != nil {
+			return err
+		}
+		return code{op(vm.OpConst, nil)}
+	},
+	interpYarn: func(args []interface{}) interface{} {
+		var c code
+		pieces := args[0].(token.Interpolation)
+		for _, p := range pieces {
+			if p.IsVar {
+				c = append(c, op(vm.OpLoad, p.Text))
+			} else {
+				c = append(c, op(vm.OpConst, p.Text))
+			}
+		}
+		return append(c, count(vm.OpSmoosh, len(pieces)))
+	},
+	ident: func(args []interface{}) interface{} {
+		c := newBytecodePlace(args[0], args[1]).get()
+		if call, ok := args[2].(code); ok {
+			c = append(c, call...)
+		}
+		return c
+	},
+	maekXAtype: func(args []interface{}) interface{} {
+		return append(join(args[1].(code)), op(vm.OpCast, args[2]))
+	},
+	notExpr: func(args []interface{}) interface{} {
+		return append(join(args[1].(code)), op(vm.OpNot, nil))
+	},
+	bothofXAnY: func(args []interface{}) interface{} {
+		y := append(join(args[3].(code)), op(vm.OpTroof, nil))
+		return join(args[1].(code), code{jump(vm.OpAndJump, len(y))}, y)
+	},
+	eitherofXAnY: func(args []interface{}) interface{} {
+		y := append(join(args[3].(code)), op(vm.OpTroof, nil))
+		return join(args[1].(code), code{jump(vm.OpOrJump, len(y))}, y)
+	},
+	wonofXAnY:    binary(vm.OpXor),
+	allofList:    shortCircuit(vm.OpAndJump, true),
+	anyofList:    shortCircuit(vm.OpOrJump, false),
+	bothsaemXAnY: binary(vm.OpSaem),
+	diffrintXAnY: binary(vm.OpDiffrint),
+	biggrofXAnY:  binary(vm.OpMax),
+	smallrofXAnY: binary(vm.OpMin),
+	sumofXAnY:    binary(vm.OpAdd),
+	diffofXAnY:   binary(vm.OpSub),
+	prodofXAnY:   binary(vm.OpMul),
+	quoshofXAnY:  binary(vm.OpDiv),
+	modofXAnY:    binary(vm.OpMod),
+	smooshList: func(args []interface{}) interface{} {
+		exprs := args[1].([]code)
+		return append(join(exprs...), count(vm.OpSmoosh, len(exprs)))
+	},
+	iizCall: func(args []interface{}) interface{} {
+		name := args[1].(bytecodeVar)
+		c := join(name.srs)
+		call := op(vm.OpCall, name.ident)
+		if args[2] != nil {
+			for _, a := range args[2].([]interface{}) {
+				c = append(c, a.(code)...)
+				call.B++
+			}
+		}
+		if name.srs != nil {
+			call = count(vm.OpCallSrs, int(call.B))
+		}
+		return append(c, call)
+	},
+	canHas: func(args []interface{}) interface{} {
+		lib := args[1].(libImport)
+		lib.prefix, _ = args[2].(string)
+		return code{op(vm.OpCanHas, lib)}
+	},
+	varIdent: func(args []interface{}) interface{} {
+		return bytecodeVar{ident: args[0].(string)}
+	},
+	srsVar: func(args []interface{}) interface{} {
+		return bytecodeVar{srs: args[1].(code)}
+	},
+}
+
+// funcCode assembles the body of a function, which ends by returning IT.
+// A GTFO returns NOOB.
+func funcCode(name string, params []string, block interface{}) *vm.Func {
+	body := append(blockCode(block), op(vm.OpLoad, "IT"), op(vm.OpReturn, nil))
+	for i := range body {
+		if body[i].Op == vm.OpGTFO {
+			body[i] = jump(vm.OpReturn, 1)
+		}
+	}
+	return assemble(name, params, body)
+}
+
+// A bytecodeVar is a varName, with the code that pushes its SRS expression
+type bytecodeVar struct {
+	ident string
+	srs   code
+}
+
+func (v bytecodeVar) load() code {
+	if v.srs == nil {
+		return code{op(vm.OpLoad, v.ident)}
+	}
+	return append(join(v.srs), op(vm.OpLoadSrs, nil))
+}
+
+// store assigns the variable the value that val pushes
+func (v bytecodeVar) store(val code) code {
+	if v.srs == nil {
+		return append(join(val), op(vm.OpStore, v.ident))
+	}
+	return append(join(v.srs, val), op(vm.OpStoreSrs, nil))
+}
+
+func (v bytecodeVar) declare(val code) code {
+	if v.srs == nil {
+		return append(join(val), op(vm.OpDeclare, v.ident))
+	}
+	return append(join(v.srs, val), op(vm.OpDeclareSrs, nil))
+}
+
+// update replaces the value of the variable with what f leaves in place of it,
+// working out an SRS name just once
+func (v bytecodeVar) update(f code) code {
+	if v.srs == nil {
+		return append(join(code{op(vm.OpLoad, v.ident)}, f), op(vm.OpStore, v.ident))
+	}
+	return append(join(v.srs, code{count(vm.OpDup, 1), op(vm.OpLoadSrs, nil)}, f), op(vm.OpStoreSrs, nil))
+}
+
+// A bytecodePlace is the place a statement works on, with its slots compiled
+type bytecodePlace struct {
+	name  bytecodeVar
+	slots []code
+}
+
+type bytecodePred func(bytecodePlace) code
+
+func newBytecodePlace(name, slots interface{}) bytecodePlace {
+	p := bytecodePlace{name: name.(bytecodeVar)}
+	for _, s := range slots.([]interface{}) {
+		p.slots = append(p.slots, s.(code))
+	}
+	return p
+}
+
+// get pushes the value of the place
+func (p bytecodePlace) get() code {
+	c := p.name.load()
+	for _, s := range p.slots {
+		c = append(append(c, s...), op(vm.OpSlot, nil))
+	}
+	return c
+}
+
+// resolve pushes the BUKKIT and the name of the slot that a place with slots is
+func (p bytecodePlace) resolve() code {
+	last := len(p.slots) - 1
+	return append(bytecodePlace{p.name, p.slots[:last]}.get(), p.slots[last]...)
+}
+
+// A bytecodeClause is a MEBBE: a condition and the block run if it's WIN
+type bytecodeClause struct {
+	cond, body code
+}
+
+// A bytecodeOmg is a labelled block in a WTF? switch
+type bytecodeOmg struct {
+	label interface{}
+	body  code
+	pos   token.Pos
+}
+
+// count makes an instruction that works on the top n values of the stack
+func count(o vm.Op, n int) ins {
+	return ins{Instr: vm.Instr{Op: o, A: int32(n)}}
+}
+
+// binary compiles X AN Y to the code for X, the code for Y, then o
+func binary(o vm.Op) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		return append(join(args[1].(code), args[3].(code)), op(o, nil))
+	}
+}
+
+// shortCircuit compiles ALL OF or ANY OF to the code for each operand followed by
+// o, which leaves the result and jumps to the end as soon as it's known.
+// If no operand decides it, the result is end.
+func shortCircuit(o vm.Op, end bool) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		var c code
+		var jumps []int
+		for _, e := range args[1].([]code) {
+			c = append(c, e...)
+			jumps = append(jumps, len(c))
+			c = append(c, ins{})
+		}
+		c = append(c, op(vm.OpConst, end))
+		for _, i := range jumps {
+			c[i] = jump(o, len(c)-i-1)
+		}
+		return c
+	}
+}
+
+// runCode runs the top level of a program compiled to bytecode.  The machine has
+// globals of its own while it runs, which are copied back even if it fails.
+func (r *run) runCode(f *vm.Func, vars map[string]interface{}) map[string]interface{} {
+	globals := make(map[string]vm.Value, len(vars))
+	for name, val := range vars {
+		globals[name] = vm.ValueOf(val)
+	}
+	defer func() {
+		for name, val := range globals {
+			vars[name] = val.Interface()
+		}
+	}()
+	if r.machine == nil {
+		r.machine = vm.New(vmHost{r})
+	}
+	r.machine.Run(f, globals)
+	return vars
+}
+
+// callCode runs a function compiled to bytecode for function.call, with a machine
+// of the run's own if the program was compiled to closures
+func (f *function) callCode(me interface{}, args []interface{}, r *run) interface{} {
+	vals := make([]vm.Value, 0, len(args)+1)
+	if f.method {
+		vals = append(vals, vm.ValueOf(me))
+	}
+	for _, a := range args {
+		vals = append(vals, vm.ValueOf(a))
+	}
+	if r.machine == nil {
+		r.machine = vm.New(vmHost{r})
+	}
+	caller := r.top
+	r.pushFrame(f.name).funcs = f.funcs
+	result := r.machine.Call(f.code, vals)
+	r.top = caller
+	return result.Interface()
+}
+
+// vmHost does for the machine what the closures do for themselves
+type vmHost struct {
+	*run
+}
+
+func (h vmHost) Pos(pos token.Pos) {
+	h.top.pos = pos
+	h.step()
+}
+
+func (h vmHost) Step() {
+	h.step()
+}
+
+// mathOps are the operations of the math instructions, for values the machine doesn't handle itself
+var mathOps = map[vm.Op]struct {
+	intOper   func(int64, int64) int64
+	floatOper func(float64, float64) float64
+}{
+	vm.OpAdd: {sumInt, sumFloat},
+	vm.OpSub: {diffInt, diffFloat},
+	vm.OpMul: {prodInt, prodFloat},
+	vm.OpDiv: {quoshInt, quoshFloat},
+	vm.OpMod: {modInt, modFloat},
+	vm.OpMax: {biggrInt, biggrFloat},
+	vm.OpMin: {smallrInt, smallrFloat},
+}
+
+func (h vmHost) Binary(o vm.Op, x, y vm.Value) vm.Value {
+	ops := mathOps[o]
+	return vm.ValueOf(doMath(x.Interface(), y.Interface(), ops.intOper, ops.floatOper))
+}
+
+func (h vmHost) Saem(x, y vm.Value) bool {
+	return saem(x.Interface(), y.Interface())
+}
+
+func (h vmHost) Troof(x vm.Value) bool {
+	return troof(x.Interface())
+}
+
+func (h vmHost) Cast(x vm.Value, typ string) vm.Value {
+	return vm.ValueOf(h.allocCast(x.Interface(), castFunc(typ)(x.Interface())))
+}
+
+func (h vmHost) Smoosh(vals []vm.Value) vm.Value {
+	var builder strings.Builder
+	for _, v := range vals {
+		builder.WriteString(yarn(v.Interface(), false))
+	}
+	h.alloc(builder.Len())
+	return vm.ValueOf(builder.String())
+}
+
+func (h vmHost) Visible(vals []vm.Value, newline bool) {
+	var builder strings.Builder
+	for _, v := range vals {
+		builder.WriteString(yarn(v.Interface(), false))
+	}
+	if newline {
+		builder.WriteByte('\n')
+	}
+	h.output(builder.String())
+}
+
+func (h vmHost) Gimmeh() vm.Value {
+	return vm.ValueOf(h.input())
+}
+
+func (h vmHost) Define(f *vm.Func) {
+	h.top.funcs[f.Name] = &function{name: f.Name, nargs: len(f.Params), params: f.Params, code: f,
+		funcs: h.top.funcs}
+}
+
+// Call runs a function in a frame of its own, like function.call
+func (h vmHost) Call(name string, args []vm.Value) vm.Value {
+	f, ok := h.top.funcs[name]
+	if !ok {
+		raise(UndefinedFunc, "Call to undefined function: %s", name)
+	}
+	if f.code == nil {
+		vals := make([]interface{}, len(args))
+		for i, a := range args {
+			vals[i] = a.Interface()
+		}
+		return vm.ValueOf(f.call(nil, vals, h.run))
+	}
+	f.checkArgs(len(args))
+	caller := h.top
+	h.pushFrame(f.name).funcs = f.funcs
+	result := h.machine.Call(f.code, args)
+	h.top = caller
+	return result
+}
+
+func (h vmHost) Undefined(name string, assign bool) {
+	if assign {
+		raise(UndefinedVar, "Assignment to undefined variable: %s", name)
+	}
+	raise(UndefinedVar, "Reference to undefined variable: %s", name)
+}
+
+func (h vmHost) Misplaced(o vm.Op) {
+	if o == vm.OpGTFO {
+		raise(BadJump, "GTFO outside of a switch, loop or function")
+	}
+	raise(BadJump, "FOUND YR outside of a function")
+}
+
+func (h vmHost) CanHas(lib vm.Value, declare func(name string, val vm.Value)) {
+	h.canHas(lib.Interface().(libImport), func(name string, val interface{}) {
+		declare(name, vm.ValueOf(val))
+	})
+}
+
+func (h vmHost) Name(x vm.Value) string {
+	return srsName(x.Interface())
+}
+
+func (h vmHost) Slot(obj, name vm.Value) vm.Value {
+	return vm.ValueOf(getSlot(obj.Interface(), name.Interface()))
+}
+
+func (h vmHost) SetSlot(obj, name, val vm.Value, declare bool) {
+	h.setSlot(obj.Interface(), name.Interface(), val.Interface(), declare)
+}
+
+// Method calls a function in a slot of obj, with obj as its ME
+func (h vmHost) Method(obj, name vm.Value, args []vm.Value) vm.Value {
+	f := method(obj.Interface(), name.Interface())
+	if f.code == nil { // from a BUKKIT made by an interpreter without bytecode
+		vals := make([]interface{}, len(args))
+		for i, a := range args {
+			vals[i] = a.Interface()
+		}
+		return vm.ValueOf(f.call(obj.Interface(), vals, h.run))
+	}
+	f.checkArgs(len(args))
+	caller := h.top
+	h.pushFrame(f.name).funcs = f.funcs
+	result := h.machine.Call(f.code, append([]vm.Value{obj}, args...))
+	h.top = caller
+	return result
+}
+
+func (h vmHost) Liek(parent vm.Value) vm.Value {
+	h.alloc(bukkitSize)
+	return vm.ValueOf(liek(parent.Interface()))
+}
+
+func (h vmHost) Func(f *vm.Func) vm.Value {
+	return vm.ValueOf(&function{name: f.Name, nargs: len(f.Params) - 1, params: f.Params[1:], code: f, method: true,
+		funcs: h.top.funcs})
+}
@@ -1,76 +1,214 @@
 package lang
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"lol/token"
+	"lol/vm"
+	"math"
 	"strconv"
 	"strings"
 )
 
-type namespace struct {
-	vars map[string]interface{}
+type statement func(*namespace) flow
+type expr func(*namespace) interface{}
+type pred func(place, *namespace)
+
+// Program is a compiled Lolcode program, from HAI to KTHXBYE
+type Program struct {
+	body []statement
+	code *vm.Func     // set instead of body when compiled to bytecode
+	in   *Interpreter // the interpreter that compiled it, if any
+	file string       // the name given to CompileFile, if any
 }
 
-func (ns *namespace) getOrPanic(ident string) interface{} {
-	if v, ok := ns.vars[ident]; ok {
-		return v
+// flow tells the enclosing block how to carry on after a statement
+type flow int
+
+const (
+	flowNext  flow = iota // go on to the next statement
+	flowGTFO              // leave the innermost enclosing switch, loop or function
+	flowFound             // return from the function with the value in its frame's ret
+)
+
+// runBlock runs statements until one of them breaks the flow
+func runBlock(body []statement, ns *namespace) flow {
+	for _, s := range body {
+		if f := s(ns); f != flowNext {
+			return f
+		}
 	}
-	panic("Reference to undefined variable: " + ident)
+	return flowNext
 }
 
-func (ns *namespace) putOrPanic(ident string, val interface{}) {
-	if _, ok := ns.vars[ident]; !ok {
-		panic("Assignment to undefined variable: " + ident)
-	}
-	ns.vars[ident] = val
+// program
+func haiBlock(args []interface{}) interface{} {
+	return &Program{body: block(args[3])}
 }
 
-type statement func(*namespace)
-type expr func(*namespace) interface{}
-type pred func(string, *namespace)
+func block(arg interface{}) []statement {
+	stmts := arg.([]interface{})
+	body := make([]statement, len(stmts))
+	for i, s := range stmts {
+		body[i] = s.(statement)
+	}
+	return body
+}
 
 // pred
 func emptyPredicate(args []interface{}) interface{} {
-	return pred(func(ident string, ns *namespace) {
-		ns.vars["IT"] = ns.getOrPanic(ident)
+	return pred(func(p place, ns *namespace) {
+		ns.setIT(p.get(ns))
 	})
 }
 
 func rExpr(args []interface{}) interface{} {
 	expr := args[1].(expr)
-	return pred(func(ident string, ns *namespace) {
-		ns.putOrPanic(ident, expr(ns))
+	return pred(func(p place, ns *namespace) {
+		p.put(ns, expr)
 	})
 }
 
 func isnowAtype(args []interface{}) interface{} {
 	cast := castFunc(args[1].(string))
-	return pred(func(id string, ns *namespace) {
-		ns.vars[id] = cast(ns.getOrPanic(id))
+	return pred(func(p place, ns *namespace) {
+		p.update(ns, func(val interface{}) interface{} {
+			return ns.frame.run.allocCast(val, cast(val))
+		})
 	})
 }
 
 // statement
+func atPos(pos token.Pos, args []interface{}) interface{} {
+	s := args[0].(statement)
+	return statement(func(ns *namespace) flow {
+		ns.frame.pos = pos
+		ns.frame.run.step()
+		return s(ns)
+	})
+}
+
 func varPredicate(args []interface{}) interface{} {
-	ident := args[0].(string)
-	pred := args[1].(pred)
-	return statement(func(ns *namespace) {
-		pred(ident, ns)
+	p := newPlace(args[0], args[1])
+	pred := args[2].(pred)
+	return statement(func(ns *namespace) flow {
+		pred(p, ns)
+		return flowNext
 	})
 }
 
+// A varName is the name of a variable or function: an identifier, or an
+// expression given with SRS whose value is cast to a YARN when it's needed
+type varName struct {
+	ident string
+	srs   expr
+}
+
+func varIdent(args []interface{}) interface{} {
+	return varName{ident: args[0].(string)}
+}
+
+func srsVar(args []interface{}) interface{} {
+	return varName{srs: args[1].(expr)}
+}
+
+func (v varName) get(ns *namespace) string {
+	if v.srs == nil {
+		return v.ident
+	}
+	return srsName(v.srs(ns))
+}
+
 func ihasaVarItz(args []interface{}) interface{} {
-	ident := args[1].(string)
+	name := args[1].(varName)
 	if args[2] == nil { // ITZ is optional
-		return statement(func(ns *namespace) {
-			ns.vars[ident] = nil
+		return statement(func(ns *namespace) flow {
+			ns.declare(name.get(ns), nil)
+			return flowNext
 		})
 	}
 	expr := args[2].(expr)
-	return statement(func(ns *namespace) {
-		ns.vars[ident] = expr(ns)
+	return statement(func(ns *namespace) flow {
+		ident := name.get(ns)
+		ns.declare(ident, expr(ns))
+		return flowNext
+	})
+}
+
+func visible(args []interface{}) interface{} {
+	exprs := args[1].([]expr)
+	newline := args[2] == nil // ! suppresses the newline
+	return statement(func(ns *namespace) flow {
+		var builder strings.Builder
+		for _, e := range exprs {
+			builder.WriteString(yarn(e(ns), false))
+		}
+		if newline {
+			builder.WriteByte('\n')
+		}
+		ns.frame.run.output(builder.String())
+		return flowNext
+	})
+}
+
+// output writes s for VISIBLE
+func (r *run) output(s string) {
+	if _, err := io.WriteString(r.stdout, s); err != nil {
+		raise(BadIO, "Failed to write output: %v", err)
+	}
+}
+
+func gimmeh(args []interface{}) interface{} {
+	name := args[1].(varName)
+	return statement(func(ns *namespace) flow {
+		ident := name.get(ns)
+		ns.putOrPanic(ident, ns.frame.run.input())
+		return flowNext
 	})
 }
 
+// input reads a line for GIMMEH, without its line ending.  If the run's context can
+// be done, the line is read in a goroutine, so that waiting for it can be canceled;
+// a read that's canceled is left going, and the next GIMMEH takes its line.
+func (r *run) input() string {
+	if r.reading == nil && r.ctx.Done() == nil {
+		line, err := r.stdin.ReadString('\n')
+		return r.gotInput(line, err)
+	}
+	if r.reading == nil {
+		r.reading = make(chan inputLine, 1)
+		go func(stdin *bufio.Reader, c chan<- inputLine) {
+			line, err := stdin.ReadString('\n')
+			c <- inputLine{line, err}
+		}(r.stdin, r.reading)
+	}
+	select {
+	case in := <-r.reading:
+		r.reading = nil
+		return r.gotInput(in.line, in.err)
+	case <-r.ctx.Done():
+		r.canceled()
+		return ""
+	}
+}
+
+// An inputLine is the result of reading a line for GIMMEH
+type inputLine struct {
+	line string
+	err  error
+}
+
+// gotInput checks a line read for GIMMEH, and strips its line ending
+func (r *run) gotInput(line string, err error) string {
+	if err != nil && err != io.EOF {
+		raise(BadIO, "Failed to read input: %v", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	r.alloc(len(line))
+	return line
+}
+
 // Expressions
 func itzExpr(args []interface{}) interface{} {
 	return args[1]
@@ -78,8 +216,9 @@ func itzExpr(args []interface{}) interface{} {
 
 func bareExpr(args []interface{}) interface{} {
 	expr := args[0].(expr)
-	return statement(func(ns *namespace) {
-		ns.vars["IT"] = expr(ns)
+	return statement(func(ns *namespace) flow {
+		ns.setIT(expr(ns))
+		return flowNext
 	})
 }
 
@@ -89,13 +228,49 @@ func literal(args []interface{}) interface{} {
 	})
 }
 
-func ident(args []interface{}) interface{} {
-	ident := args[0].(string)
+// noob is the value NOOB, named by its type.  The other types have no such value.
+func noob(args []interface{}) interface{} {
+	if err := checkNoob(args[0]); err != nil {
+		return err
+	}
+	return literal([]interface{}{nil})
+}
+
+func checkNoob(typ interface{}) error {
+	if typ != "NOOB" {
+		return fmt.Errorf("%s is a type, not a value", typ)
+	}
+	return nil
+}
+
+func interpYarn(args []interface{}) interface{} {
+	pieces := args[0].(token.Interpolation)
 	return expr(func(ns *namespace) interface{} {
-		return ns.getOrPanic(ident)
+		var builder strings.Builder
+		for _, p := range pieces {
+			if p.IsVar {
+				builder.WriteString(yarn(ns.getOrPanic(p.Text), false))
+			} else {
+				builder.WriteString(p.Text)
+			}
+		}
+		ns.frame.run.alloc(builder.Len())
+		return builder.String()
 	})
 }
 
+// ident is the value of a variable, or of a slot reached from it,
+// or what a function in that slot returns when called on it with IZ
+func ident(args []interface{}) interface{} {
+	p := newPlace(args[0], args[1])
+	if c, ok := args[2].(methodCall); ok {
+		return expr(func(ns *namespace) interface{} {
+			return c.call(p.get(ns), ns)
+		})
+	}
+	return expr(p.get)
+}
+
 // If x is int64 and y is float64, promote x to float64 (and vice versa)
 func saem(x, y interface{}) bool {
 	switch v := x.(type) {
@@ -127,33 +302,54 @@ func diffrintXAnY(args []interface{}) interface{} {
 	})
 }
 
+// getNumericValue implicitly casts an operand of a math operator.  A YARN becomes a
+// NUMBAR if it has a decimal point and a NUMBR otherwise; useFloat makes NUMBRs NUMBARs.
 func getNumericValue(val interface{}, useFloat bool) interface{} {
-	typePanicMsg := "Cannot perform numerical operation on type "
 	switch v := val.(type) {
 	case nil:
-		panic(typePanicMsg + "NOOB")
+		raise(BadCast, "Cannot implicitly cast NOOB to a number")
 	case bool:
-		panic(typePanicMsg + "TROOF")
-	case int64:
-		if useFloat {
-			return float64(v)
-		}
-		return v
-	case float64:
-		return v
+		val = numbr(v)
 	case string:
-		if !useFloat {
-			if i, err := strconv.ParseInt(v, 0, 64); err == nil {
-				return i
-			}
-		}
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			return f
+		n, ok := parseNumber(v)
+		if !ok {
+			raise(BadCast, "Cannot cast YARN %q to a number", v)
 		}
-		panic("Failed to parse numeric value from string: " + v)
+		val = n
+	case int64, float64:
 	default:
-		panic(typePanicMsg + fmt.Sprintf("%T", v))
+		raise(BadType, "Cannot perform numerical operation on type %s", typeName(val))
+	}
+	if i, ok := val.(int64); ok && useFloat {
+		return float64(i)
+	}
+	return val
+}
+
+// parseNumber reads a YARN holding a NUMBR or NUMBAR literal: digits, with a
+// leading - if negative, and a single decimal point for a NUMBAR
+func parseNumber(s string) (interface{}, bool) {
+	digits, point := 0, false
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '-' && i == 0:
+		case c == '.' && !point:
+			point = true
+		default:
+			return nil, false
+		}
 	}
+	if digits == 0 {
+		return nil, false
+	}
+	if point {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	return i, err == nil
 }
 
 func numbr(x interface{}) int64 { // explicit cast
@@ -168,12 +364,19 @@ func numbr(x interface{}) int64 { // explicit cast
 	case int64:
 		return x
 	case float64:
+		if !(x >= math.MinInt64 && x < math.MaxInt64) { // NaN fails too
+			raise(BadCast, "Cannot cast NUMBAR %s to NUMBR", numbarYarn(x))
+		}
 		return int64(x)
 	case string:
-		i, _ := strconv.ParseInt(x, 0, 64)
-		return i
+		n, ok := parseNumber(x)
+		if !ok {
+			raise(BadCast, "Cannot cast YARN %q to NUMBR", x)
+		}
+		return numbr(n)
 	default:
-		panic(fmt.Sprintf("Cannot cast %t to NUMBR", x))
+		raise(BadCast, "Cannot cast %s to NUMBR", typeName(x))
+		return 0
 	}
 }
 
@@ -191,28 +394,38 @@ func numbar(x interface{}) float64 { // explicit cast
 	case float64:
 		return x
 	case string:
-		f, _ := strconv.ParseFloat(x, 64)
-		return f
+		n, ok := parseNumber(x)
+		if !ok {
+			raise(BadCast, "Cannot cast YARN %q to NUMBAR", x)
+		}
+		return numbar(n)
 	default:
-		panic(fmt.Sprintf("Cannot cast %t to NUMBAR", x))
+		raise(BadCast, "Cannot cast %s to NUMBAR", typeName(x))
+		return 0
 	}
 }
 
 func makeMathExpr(left, right expr, intOper func(int64, int64) int64,
 	floatOper func(float64, float64) float64) expr {
 	return func(ns *namespace) interface{} {
-		switch v1 := getNumericValue(left(ns), false).(type) {
+		return doMath(left(ns), right(ns), intOper, floatOper)
+	}
+}
+
+// doMath applies intOper to two NUMBRs, or floatOper if either is a NUMBAR
+func doMath(x, y interface{}, intOper func(int64, int64) int64,
+	floatOper func(float64, float64) float64) interface{} {
+	switch v1 := getNumericValue(x, false).(type) {
+	case int64:
+		switch v2 := getNumericValue(y, false).(type) {
 		case int64:
-			switch v2 := getNumericValue(right(ns), false).(type) {
-			case int64:
-				return intOper(v1, v2)
-			default: // float64
-				return floatOper(float64(v1), v2.(float64))
-			}
+			return intOper(v1, v2)
 		default: // float64
-			v2 := getNumericValue(right(ns), true)
-			return floatOper(v1.(float64), v2.(float64))
+			return floatOper(float64(v1), v2.(float64))
 		}
+	default: // float64
+		v2 := getNumericValue(y, true)
+		return floatOper(v1.(float64), v2.(float64))
 	}
 }
 
@@ -255,57 +468,106 @@ func smallrofXAnY(args []interface{}) interface{} {
 }
 
 func sumofXAnY(args []interface{}) interface{} {
-	return makeMathExpr(args[1].(expr), args[3].(expr),
-		func(a, b int64) int64 { return a + b },
-		func(a, b float64) float64 { return a + b },
-	)
+	return makeMathExpr(args[1].(expr), args[3].(expr), sumInt, sumFloat)
 }
 
 func diffofXAnY(args []interface{}) interface{} {
-	return makeMathExpr(args[1].(expr), args[3].(expr),
-		func(a, b int64) int64 { return a - b },
-		func(a, b float64) float64 { return a - b },
-	)
+	return makeMathExpr(args[1].(expr), args[3].(expr), diffInt, diffFloat)
 }
 
 func prodofXAnY(args []interface{}) interface{} {
-	return makeMathExpr(args[1].(expr), args[3].(expr),
-		func(a, b int64) int64 { return a * b },
-		func(a, b float64) float64 { return a * b },
-	)
+	return makeMathExpr(args[1].(expr), args[3].(expr), prodInt, prodFloat)
 }
 
 func quoshofXAnY(args []interface{}) interface{} {
-	return makeMathExpr(args[1].(expr), args[3].(expr),
-		func(a, b int64) int64 { return a / b },
-		func(a, b float64) float64 { return a / b },
-	)
+	return makeMathExpr(args[1].(expr), args[3].(expr), quoshInt, quoshFloat)
 }
 
 func modofXAnY(args []interface{}) interface{} {
-	return makeMathExpr(args[1].(expr), args[3].(expr),
-		func(a, b int64) int64 { return a % b },
-		func(a, b float64) float64 { panic("Cannot use MOD OF with type NUMBAR") },
-	)
+	return makeMathExpr(args[1].(expr), args[3].(expr), modInt, modFloat)
 }
 
+func sumInt(a, b int64) int64       { return a + b }
+func sumFloat(a, b float64) float64 { return a + b }
+
+func diffInt(a, b int64) int64       { return a - b }
+func diffFloat(a, b float64) float64 { return a - b }
+
+func prodInt(a, b int64) int64       { return a * b }
+func prodFloat(a, b float64) float64 { return a * b }
+
+func quoshInt(a, b int64) int64 {
+	if b == 0 {
+		raise(DivideByZero, "Division by zero")
+	}
+	return a / b
+}
+
+func quoshFloat(a, b float64) float64 {
+	if b == 0 {
+		raise(DivideByZero, "Division by zero")
+	}
+	return a / b
+}
+
+func modInt(a, b int64) int64 {
+	if b == 0 {
+		raise(DivideByZero, "Division by zero")
+	}
+	return a % b
+}
+
+func modFloat(a, b float64) float64 {
+	raise(BadType, "Cannot use MOD OF with type NUMBAR")
+	return 0
+}
+
+// yarn casts to YARN.  Only an explicit cast accepts NOOB, which becomes the empty YARN.
 func yarn(x interface{}, isExplicit bool) string {
 	switch x := x.(type) {
 	case nil:
 		if isExplicit {
 			return ""
 		}
-		panic("Cannot implicitly cast NOOB to YARN")
+		raise(BadCast, "Cannot implicitly cast NOOB to YARN")
+		return ""
 	case bool:
 		if x {
 			return "WIN"
 		}
 		return "FAIL"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return numbarYarn(x)
+	case string:
+		return x
 	default:
-		return fmt.Sprintf("%v", x)
+		raise(BadCast, "Cannot cast %s to YARN", typeName(x))
+		return ""
+	}
+}
+
+// numbarYarn writes a NUMBAR truncated to two decimal places
+func numbarYarn(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return s
 	}
+	point := strings.IndexByte(s, '.')
+	if point < 0 {
+		return s + ".00"
+	}
+	s = (s + "0")[:point+3]
+	if s == "-0.00" {
+		return "0.00"
+	}
+	return s
 }
 
+// troof casts to TROOF, implicitly or explicitly.  NOOB, the empty YARN, numerical zero
+// and a BUKKIT without slots are FAIL, and all else is WIN; even the YARN "0", which
+// isn't a number.
 func troof(x interface{}) bool {
 	switch x := x.(type) {
 	case bool:
@@ -318,33 +580,52 @@ func troof(x interface{}) bool {
 		return x != 0
 	case float64:
 		return x != 0
+	case *Bukkit:
+		for b := x; b != nil; b = b.parent {
+			if len(b.names) > 0 {
+				return true
+			}
+		}
+		return false
 	default:
-		panic(fmt.Sprintf("Cannot cast type %t to TROOF", x))
+		raise(BadCast, "Cannot cast type %s to TROOF", typeName(x))
+		return false
 	}
 }
 
 func castFunc(t string) func(interface{}) interface{} {
 	switch t {
-	case "A NOOB":
+	case "NOOB":
 		return func(interface{}) interface{} {
 			return nil
 		}
-	case "A TROOF":
+	case "TROOF":
 		return func(x interface{}) interface{} {
 			return troof(x)
 		}
-	case "A NUMBR":
+	case "NUMBR":
 		return func(x interface{}) interface{} {
 			return numbr(x)
 		}
-	case "A NUMBAR":
+	case "NUMBAR":
 		return func(x interface{}) interface{} {
 			return numbar(x)
 		}
-	default: // "A YARN"
+	case "YARN":
 		return func(x interface{}) interface{} {
 			return yarn(x, true)
 		}
+	default: // "BUKKIT"
+		return func(x interface{}) interface{} {
+			switch x.(type) {
+			case nil:
+				return NewBukkit()
+			case *Bukkit:
+				return x
+			}
+			raise(BadCast, "Cannot cast %s to BUKKIT", typeName(x))
+			return nil
+		}
 	}
 }
 
@@ -355,6 +636,7 @@ func smooshList(args []interface{}) interface{} {
 		for _, e := range exprs {
 			builder.WriteString(yarn(e(ns), false))
 		}
+		ns.frame.run.alloc(builder.Len())
 		return builder.String()
 	})
 }
@@ -363,7 +645,8 @@ func maekXAtype(args []interface{}) interface{} {
 	e := args[1].(expr)
 	cast := castFunc(args[2].(string))
 	return expr(func(ns *namespace) interface{} {
-		return cast(e(ns))
+		x := e(ns)
+		return ns.frame.run.allocCast(x, cast(x))
 	})
 }
 
@@ -0,0 +1,191 @@
+package lang
+
+import (
+	"fmt"
+	"lol/parser"
+	"lol/token"
+)
+
+// A clause is a block of statements run if its condition is WIN
+type clause struct {
+	cond expr
+	body []statement
+}
+
+// O RLY? branches on IT: YA RLY runs if IT is WIN,
+// otherwise the first MEBBE whose condition is WIN, otherwise NO WAI.
+func orly(args []interface{}) interface{} {
+	yarly := block(args[4])
+	var mebbes []clause
+	for _, m := range args[5].([]interface{}) {
+		mebbes = append(mebbes, m.(clause))
+	}
+	var nowai []statement
+	if args[6] != nil {
+		nowai = args[6].([]statement)
+	}
+	return statement(func(ns *namespace) flow {
+		if troof(ns.getOrPanic("IT")) {
+			return runBlock(yarly, ns)
+		}
+		for _, m := range mebbes {
+			if troof(m.cond(ns)) {
+				return runBlock(m.body, ns)
+			}
+		}
+		return runBlock(nowai, ns)
+	})
+}
+
+func mebbe(args []interface{}) interface{} {
+	return clause{args[1].(expr), block(args[3])}
+}
+
+func noWai(args []interface{}) interface{} {
+	return block(args[2])
+}
+
+// An omg is a labelled block in a WTF? switch
+type omg struct {
+	label interface{}
+	body  []statement
+	pos   token.Pos
+}
+
+// WTF? compares IT to each OMG label in turn, and runs the statements
+// from the first match, falling through the following labels until GTFO.
+// OMGWTF is run if nothing matches.
+func wtf(args []interface{}) interface{} {
+	var omgs []omg
+	var labels []interface{}
+	for _, o := range args[2].([]interface{}) {
+		o := o.(omg)
+		if err := checkLabel(labels, o.label, o.pos); err != nil {
+			return err
+		}
+		omgs = append(omgs, o)
+		labels = append(labels, o.label)
+	}
+	var omgwtf []statement
+	if args[3] != nil {
+		omgwtf = args[3].([]statement)
+	}
+	return statement(func(ns *namespace) flow {
+		it := ns.getOrPanic("IT")
+		start := len(omgs)
+		for i, o := range omgs {
+			if saem(it, o.label) {
+				start = i
+				break
+			}
+		}
+		for _, o := range omgs[start:] {
+			if f := runBlock(o.body, ns); f != flowNext {
+				return breakOut(f)
+			}
+		}
+		return breakOut(runBlock(omgwtf, ns))
+	})
+}
+
+// checkLabel rejects an OMG label the same as one of the labels before it
+func checkLabel(labels []interface{}, label interface{}, pos token.Pos) error {
+	for _, prev := range labels {
+		if saem(prev, label) {
+			return &parser.SyntaxError{Pos: pos, Msg: fmt.Sprintf("Duplicate OMG label in WTF?: %v", label)}
+		}
+	}
+	return nil
+}
+
+// breakOut absorbs a GTFO that leaves a switch or loop
+func breakOut(f flow) flow {
+	if f == flowGTFO {
+		return flowNext
+	}
+	return f
+}
+
+func omgLabel(pos token.Pos, args []interface{}) interface{} {
+	return omg{args[1], block(args[3]), pos}
+}
+
+func omgwtf(args []interface{}) interface{} {
+	return block(args[2])
+}
+
+func gtfo(args []interface{}) interface{} {
+	return statement(func(ns *namespace) flow {
+		return flowGTFO
+	})
+}
+
+// A loopOp updates the loop variable after each pass through the loop
+type loopOp struct {
+	ident string
+	step  int64
+}
+
+func uppinYrVar(args []interface{}) interface{} {
+	return loopOp{args[2].(string), 1}
+}
+
+func nerfinYrVar(args []interface{}) interface{} {
+	return loopOp{args[2].(string), -1}
+}
+
+// A loopCond decides whether to make another pass through the loop
+type loopCond func(*namespace) bool
+
+func tilExpr(args []interface{}) interface{} {
+	e := args[1].(expr)
+	return loopCond(func(ns *namespace) bool {
+		return !troof(e(ns))
+	})
+}
+
+func wileExpr(args []interface{}) interface{} {
+	e := args[1].(expr)
+	return loopCond(func(ns *namespace) bool {
+		return troof(e(ns))
+	})
+}
+
+// IM IN YR loops until its condition stops it or GTFO breaks out.
+// The loop runs in a scope of its own, where the loop variable is a
+// temporary NUMBR starting at 0.
+func loop(args []interface{}) interface{} {
+	if err := checkLoopLabels(args); err != nil {
+		return err
+	}
+	body := block(args[5])
+	op, hasOp := args[2].(loopOp)
+	cond, hasCond := args[3].(loopCond)
+	step := makeMathExpr(place{name: varName{ident: op.ident}}.get, literal([]interface{}{op.step}).(expr),
+		sumInt, sumFloat)
+	return statement(func(ns *namespace) flow {
+		scope := ns.newScope()
+		if hasOp {
+			scope.declare(op.ident, int64(0))
+		}
+		for !hasCond || cond(scope) {
+			ns.frame.run.step()
+			if f := runBlock(body, scope); f != flowNext {
+				return breakOut(f)
+			}
+			if hasOp {
+				scope.putOrPanic(op.ident, step(scope))
+			}
+		}
+		return flowNext
+	})
+}
+
+// checkLoopLabels makes sure IM OUTTA YR names the loop that IM IN YR started
+func checkLoopLabels(args []interface{}) error {
+	label, outtaLabel := args[1].(string), args[7].(string)
+	if label != outtaLabel {
+		return fmt.Errorf("IM OUTTA YR %s does not match IM IN YR %s", outtaLabel, label)
+	}
+	return nil
+}
@@ -1,77 +1,292 @@
 package lang
 
 import (
+	"fmt"
 	"lol/parser"
 	"lol/token"
+	"strconv"
+	"strings"
 )
 
 // ids of grammar nodes
 const (
-	Expr = iota + token.NumTokens
+	Source = iota + token.NumTokens
+	Block
+	Expr
 	ExprList
 	MoarList
 	Statement
+	StatementBody
 	VarPredicate
 	Itz
+	ItzValue
 	AType
+	MebbeList
+	NoWai
+	OmgList
+	Omg
+	OmgWtf
+	LoopOp
+	LoopCond
+	Params
+	MoreParams
+	Args
+	MoreArgs
+	Slots
+	SlotName
+	MethodCall
+	Var
+	LibName
+	Az
 	NumNodes
 )
 
-// Dialect is The *token.Dialect which implements the Lolcode language
-var D = parser.NewDialect(token.NumTokens, NumNodes)
+// Versions of Lolcode that HAI may declare
+const (
+	DefaultVersion = "1.2" // of a program whose HAI doesn't give a version
+	LatestVersion  = "1.3"
+)
+
+// Dialects are the *parser.Dialects which implement each version of Lolcode, compiling
+// it to closures.  1.2 follows its spec; 1.3 adds BUKKITs, their slots, SRS,
+// and CAN HAS for other files.  The lexer doesn't know the version, so the words 1.3
+// adds are reserved in 1.2 too: IZ, SRS, HAS A, LIEK A and 'Z can't be names there.
+var Dialects = make(map[string]*parser.Dialect)
+
+// D is the dialect of the latest version
+var D *parser.Dialect
+
+// bytecodeDialects compile the same versions to bytecode, for an Interpreter made WithBytecode
+var bytecodeDialects = make(map[string]*parser.Dialect)
 
+// The dialects are made by init, since CAN HAS compiles libraries with them
 func init() {
+	for _, v := range []string{"1.2", "1.3"} {
+		Dialects[v] = newDialect(&closures, v)
+		bytecodeDialects[v] = newDialect(&bytecode, v)
+	}
+	D = Dialects[LatestVersion]
+}
+
+// actions are the parse functions through which a dialect compiles Lolcode
+// for one backend.  Parse functions that only pass values on are shared.
+type actions struct {
+	haiBlock, varPredicate, ihasaVarItz, visible, gimmeh, orly, wtf, gtfo, loop, howizI, foundYr, bareExpr,
+	mebbe, noWai, omgwtf, tilExpr, wileExpr, emptyPredicate, rExpr, isnowAtype, exprMoar,
+	literal, noob, interpYarn, ident, maekXAtype, notExpr, bothofXAnY, eitherofXAnY, wonofXAnY,
+	allofList, anyofList, bothsaemXAnY, diffrintXAnY, biggrofXAnY, smallrofXAnY, sumofXAnY,
+	diffofXAnY, prodofXAnY, quoshofXAnY, modofXAnY, smooshList, iizCall,
+	izCall, izPredicate, hasaSlot, itzAtype, itzLiekA, howizObj, varIdent, srsVar, canHas parser.Parser
+	atPos, omgLabel parser.PosParser
+}
+
+var closures = actions{
+	haiBlock: haiBlock, varPredicate: varPredicate, ihasaVarItz: ihasaVarItz, visible: visible,
+	gimmeh: gimmeh, orly: orly, wtf: wtf, gtfo: gtfo, loop: loop, howizI: howizI, foundYr: foundYr,
+	bareExpr: bareExpr, mebbe: mebbe, noWai: noWai, omgwtf: omgwtf, tilExpr: tilExpr, wileExpr: wileExpr,
+	emptyPredicate: emptyPredicate, rExpr: rExpr, isnowAtype: isnowAtype, exprMoar: exprMoar,
+	literal: literal, noob: noob, interpYarn: interpYarn, ident: ident, maekXAtype: maekXAtype,
+	notExpr: notExpr, bothofXAnY: bothofXAnY, eitherofXAnY: eitherofXAnY, wonofXAnY: wonofXAnY,
+	allofList: allofList, anyofList: anyofList, bothsaemXAnY: bothsaemXAnY, diffrintXAnY: diffrintXAnY,
+	biggrofXAnY: biggrofXAnY, smallrofXAnY: smallrofXAnY, sumofXAnY: sumofXAnY, diffofXAnY: diffofXAnY,
+	prodofXAnY: prodofXAnY, quoshofXAnY: quoshofXAnY, modofXAnY: modofXAnY, smooshList: smooshList,
+	iizCall: iizCall, izCall: izCall, izPredicate: izPredicate, hasaSlot: hasaSlot, itzAtype: itzAtype,
+	itzLiekA: itzLiekA, howizObj: howizObj, varIdent: varIdent, srsVar: srsVar, canHas: canHas,
+	atPos: atPos, omgLabel: omgLabel,
+}
+
+// versionLess orders versions by their major, then their minor numbers, so that 1.3 comes before 1.10
+func versionLess(a, b string) bool {
+	aMajor, aMinor := splitVersion(a)
+	bMajor, bMinor := splitVersion(b)
+	return aMajor < bMajor || aMajor == bMajor && aMinor < bMinor
+}
+
+func splitVersion(v string) (major, minor int) {
+	majorText, minorText, _ := strings.Cut(v, ".")
+	major, _ = strconv.Atoi(majorText)
+	minor, _ = strconv.Atoi(minorText)
+	return major, minor
+}
+
+func newDialect(a *actions, version string) *parser.Dialect {
+	d := parser.NewDialect(token.NumTokens, NumNodes)
+	d.Name(Source, "program")
+	d.Name(Statement, "statement")
+	d.Name(StatementBody, "statement")
+	d.Name(Expr, "expression")
+	d.Name(AType, "type")
+	d.Name(SlotName, "slot")
+	d.Name(Var, "identifier")
+	d.Name(LibName, "library")
+
+	getFirst := func(args []interface{}) interface{} { return args[0] }
+	getSecond := func(args []interface{}) interface{} { return args[1] }
+
+	// since rejects the rules of a feature that came in with a later version than
+	// the dialect's, rather than leave it out and fail to explain what's wrong
+	since := func(v, feature string, p parser.Parser) parser.Parser {
+		if !versionLess(version, v) {
+			return p
+		}
+		return func(args []interface{}) interface{} {
+			return fmt.Errorf("%s needs HAI %s, not HAI %s", feature, v, version)
+		}
+	}
+	// bukkitType rejects BUKKIT as the type given in args[i] in the same way
+	bukkitType := func(i int, p parser.Parser) parser.Parser {
+		rejected := since("1.3", "BUKKIT", p)
+		return func(args []interface{}) interface{} {
+			if args[i] == "BUKKIT" {
+				return rejected(args)
+			}
+			return p(args)
+		}
+	}
+
+	// azWord checks that the word before a prefix is AZ, which is only a keyword after
+	// CAN HAS, so that programs can still use it as a name
+	azWord := func(p parser.Parser) parser.Parser {
+		return func(args []interface{}) interface{} {
+			if args[0] != "AZ" {
+				return fmt.Errorf("expected AZ or ?, not %s", args[0])
+			}
+			return p(args)
+		}
+	}
+
+	// Source
+	d.Rule(Source, a.haiBlock, token.TokHAI, -token.Literal, token.EOL, Block, token.KTHXBYE, -token.EOL)
+
+	// Block
+	d.RepRule(Block, getFirst, Statement)
+
 	// Statement
-	D.Rule(Statement, varPredicate, token.Ident, VarPredicate)
-	D.Rule(Statement, ihasaVarItz, token.IHASA, token.Ident, -Itz, token.EOL)
-	D.Rule(Statement, bareExpr, Expr, token.EOL)
+	d.PosRule(Statement, a.atPos, StatementBody)
+	d.Recover(Statement, token.EOL)
+
+	// StatementBody
+	d.Rule(StatementBody, a.varPredicate, Var, Slots, VarPredicate)
+	d.Rule(StatementBody, a.ihasaVarItz, token.IHASA, Var, -Itz, token.EOL)
+	d.Rule(StatementBody, a.visible, token.VISIBLE, ExprList, -token.BANG, token.EOL)
+	d.Rule(StatementBody, a.gimmeh, token.GIMMEH, Var, token.EOL)
+	d.Rule(StatementBody, a.orly, token.ORLY, token.EOL, token.YARLY, token.EOL, Block, MebbeList, -NoWai, token.OIC, token.EOL)
+	d.Rule(StatementBody, a.wtf, token.WTF, token.EOL, OmgList, -OmgWtf, token.OIC, token.EOL)
+	d.Rule(StatementBody, a.gtfo, token.GTFO, token.EOL)
+	d.Rule(StatementBody, a.loop, token.IMINYR, token.Ident, -LoopOp, -LoopCond, token.EOL,
+		Block, token.IMOUTTAYR, token.Ident, token.EOL)
+	d.Rule(StatementBody, a.howizI, token.HOWIZI, token.Ident, -Params, token.EOL, Block, token.IFUSAYSO, token.EOL)
+	d.Rule(StatementBody, since("1.3", "HOW IZ", a.howizObj), token.HOWIZ, Var, Slots, token.Ident, -Params, token.EOL,
+		Block, token.IFUSAYSO, token.EOL)
+	d.Rule(StatementBody, a.foundYr, token.FOUNDYR, Expr, token.EOL)
+	d.Rule(StatementBody, a.canHas, token.CANHAS, LibName, -Az, token.QUESTION, token.EOL)
+	d.Rule(StatementBody, a.bareExpr, Expr, token.EOL)
+
+	// MebbeList
+	d.RepRule(MebbeList, a.mebbe, token.MEBBE, Expr, token.EOL, Block)
+
+	// NoWai
+	d.Rule(NoWai, a.noWai, token.NOWAI, token.EOL, Block)
+
+	// OmgList
+	d.RepRule(OmgList, getFirst, Omg)
+
+	// Omg
+	d.PosRule(Omg, a.omgLabel, token.OMG, token.Literal, token.EOL, Block)
+
+	// OmgWtf
+	d.Rule(OmgWtf, a.omgwtf, token.OMGWTF, token.EOL, Block)
+
+	// LoopOp
+	d.Rule(LoopOp, uppinYrVar, token.UPPIN, token.YR, token.Ident)
+	d.Rule(LoopOp, nerfinYrVar, token.NERFIN, token.YR, token.Ident)
+
+	// LoopCond
+	d.Rule(LoopCond, a.tilExpr, token.TIL, Expr)
+	d.Rule(LoopCond, a.wileExpr, token.WILE, Expr)
+
+	// Params
+	d.Rule(Params, yrMoar, token.YR, token.Ident, MoreParams)
+	// MoreParams
+	d.RepRule(MoreParams, anYr, token.AN, token.YR, token.Ident)
+
+	// Args
+	d.Rule(Args, yrMoar, token.YR, Expr, MoreArgs)
+	// MoreArgs
+	d.RepRule(MoreArgs, anYr, token.AN, token.YR, Expr)
 
 	// Itz
-	D.Rule(Itz, itzExpr, token.ITZ, Expr)
+	d.Rule(Itz, itzExpr, token.ITZ, ItzValue)
+	// ItzValue
+	d.Rule(ItzValue, getFirst, Expr)
+	d.Rule(ItzValue, since("1.3", "ITZ A", a.itzAtype), token.A, token.Type)
+	d.Rule(ItzValue, since("1.3", "LIEK A", a.itzLiekA), token.LIEKA, Expr)
+
+	// Slots
+	d.RepRule(Slots, since("1.3", "'Z", getSecond), token.SLOTZ, SlotName)
+	// SlotName
+	d.Rule(SlotName, a.literal, token.Ident)
+	d.Rule(SlotName, getSecond, token.SRS, Expr)
+	// MethodCall
+	d.Rule(MethodCall, since("1.3", "IZ", a.izCall), token.IZ, SlotName, -Args, token.MKAY)
+
+	// Var
+	d.Rule(Var, a.varIdent, token.Ident)
+	d.Rule(Var, since("1.3", "SRS", a.srsVar), token.SRS, Expr)
+
+	// LibName
+	d.Rule(LibName, libName, token.Ident)
+	d.Rule(LibName, since("1.3", "CAN HAS a file", libFile), token.Literal)
+
+	// Az
+	d.Rule(Az, azWord(since("1.3", "AZ", getSecond)), token.Ident, token.Ident)
 
 	//AType
-	getFirst := func(args []interface{}) interface{} { return args[0] }
-	D.Rule(AType, getFirst, token.ANOOB)
-	D.Rule(AType, getFirst, token.ATROOF)
-	D.Rule(AType, getFirst, token.ANUMBR)
-	D.Rule(AType, getFirst, token.ANUMBAR)
-	D.Rule(AType, getFirst, token.AYARN)
+	d.Rule(AType, bukkitType(1, getSecond), -token.A, token.Type)
 
 	//VarPredicate
-	D.Rule(VarPredicate, emptyPredicate, token.EOL)
-	D.Rule(VarPredicate, rExpr, token.R, Expr)
-	D.Rule(VarPredicate, isnowAtype, token.ISNOW, AType)
+	d.Rule(VarPredicate, a.emptyPredicate, token.EOL)
+	d.Rule(VarPredicate, a.rExpr, token.R, Expr, token.EOL)
+	d.Rule(VarPredicate, bukkitType(1, a.isnowAtype), token.ISNOWA, token.Type, token.EOL)
+	d.Rule(VarPredicate, since("1.3", "HAS A", a.hasaSlot), token.HASA, SlotName, -Itz, token.EOL)
+	d.Rule(VarPredicate, a.izPredicate, MethodCall, token.EOL)
 
 	// ExprList
-	D.Rule(ExprList, exprMoar, Expr, MoarList, -token.MKAY)
+	d.Rule(ExprList, a.exprMoar, Expr, MoarList, -token.MKAY)
 	// MoarList
-	D.RepRule(MoarList, anExpr, -token.AN, Expr)
+	d.RepRule(MoarList, anExpr, -token.AN, Expr)
 
 	// Expr
 	// literal
-	D.Rule(Expr, literal, token.Literal)
-	// variable lookup
-	D.Rule(Expr, ident, token.Ident)
+	d.Rule(Expr, a.literal, token.Literal)
+	d.Rule(Expr, a.noob, token.Type)
+	d.Rule(Expr, a.interpYarn, token.InterpYarn)
+	// variable or slot lookup, or method call
+	d.Rule(Expr, a.ident, Var, Slots, -MethodCall)
 	// cast
-	D.Rule(Expr, maekXAtype, token.MAEK, Expr, AType)
+	d.Rule(Expr, a.maekXAtype, token.MAEK, Expr, AType)
 	// boolean
-	D.Rule(Expr, notExpr, token.NOT, Expr)
-	D.Rule(Expr, bothofXAnY, token.BOTHOF, Expr, -token.AN, Expr)
-	D.Rule(Expr, eitherofXAnY, token.EITHEROF, Expr, -token.AN, Expr)
-	D.Rule(Expr, wonofXAnY, token.WONOF, Expr, -token.AN, Expr)
-	D.Rule(Expr, allofList, token.ALLOF, ExprList)
-	D.Rule(Expr, anyofList, token.ANYOF, ExprList)
+	d.Rule(Expr, a.notExpr, token.NOT, Expr)
+	d.Rule(Expr, a.bothofXAnY, token.BOTHOF, Expr, -token.AN, Expr)
+	d.Rule(Expr, a.eitherofXAnY, token.EITHEROF, Expr, -token.AN, Expr)
+	d.Rule(Expr, a.wonofXAnY, token.WONOF, Expr, -token.AN, Expr)
+	d.Rule(Expr, a.allofList, token.ALLOF, ExprList)
+	d.Rule(Expr, a.anyofList, token.ANYOF, ExprList)
 	// comparison
-	D.Rule(Expr, bothsaemXAnY, token.BOTHSAEM, Expr, -token.AN, Expr)
-	D.Rule(Expr, diffrintXAnY, token.DIFFRINT, Expr, -token.AN, Expr)
+	d.Rule(Expr, a.bothsaemXAnY, token.BOTHSAEM, Expr, -token.AN, Expr)
+	d.Rule(Expr, a.diffrintXAnY, token.DIFFRINT, Expr, -token.AN, Expr)
 	// math
-	D.Rule(Expr, biggrofXAnY, token.BIGGROF, Expr, token.AN, Expr)
-	D.Rule(Expr, smallrofXAnY, token.SMALLROF, Expr, token.AN, Expr)
-	D.Rule(Expr, sumofXAnY, token.SUMOF, Expr, token.AN, Expr)
-	D.Rule(Expr, diffofXAnY, token.DIFFOF, Expr, token.AN, Expr)
-	D.Rule(Expr, prodofXAnY, token.PRODUKTOF, Expr, token.AN, Expr)
-	D.Rule(Expr, quoshofXAnY, token.QUOSHUNTOF, Expr, token.AN, Expr)
-	D.Rule(Expr, modofXAnY, token.MODOF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.biggrofXAnY, token.BIGGROF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.smallrofXAnY, token.SMALLROF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.sumofXAnY, token.SUMOF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.diffofXAnY, token.DIFFOF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.prodofXAnY, token.PRODUKTOF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.quoshofXAnY, token.QUOSHUNTOF, Expr, token.AN, Expr)
+	d.Rule(Expr, a.modofXAnY, token.MODOF, Expr, token.AN, Expr)
 	// smoosh
-	D.Rule(Expr, smooshList, token.SMOOSH, ExprList)
+	d.Rule(Expr, a.smooshList, token.SMOOSH, ExprList)
+	// function call
+	d.Rule(Expr, a.iizCall, token.IIZ, Var, -Args, token.MKAY)
+
+	return d
 }
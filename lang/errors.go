@@ -0,0 +1,137 @@
+package lang
+
+import (
+	"fmt"
+	"lol/token"
+	"strings"
+)
+
+// ErrorKind classifies a RuntimeError
+type ErrorKind int
+
+// Kinds of RuntimeError
+const (
+	UndefinedVar  ErrorKind = iota // a variable was used before I HAS A
+	UndefinedFunc                  // I IZ named a function that doesn't exist
+	UndefinedSlot                  // a BUKKIT has no slot of the name used
+	BadType                        // an operand has the wrong type for the operation
+	BadCast                        // a value can't be cast to the type asked for
+	BadArgCount                    // a function was called with the wrong number of arguments
+	DivideByZero
+	BadIO      // VISIBLE or GIMMEH failed to write or read
+	BadJump    // GTFO or FOUND YR with nowhere to go
+	GoFail     // a function registered from Go returned an error
+	BadLibrary // CAN HAS couldn't find or load a library, or found it loading itself
+	Internal   // the interpreter itself failed, which is a bug in it rather than the program
+
+	Canceled     // the context of the run was canceled or timed out
+	TooManySteps // the run went over its step limit
+	TooDeep      // the run went over its call depth limit
+	TooMuchAlloc // the run went over its allocation limit
+)
+
+var errorKindNames = []string{
+	"undefined variable",
+	"undefined function",
+	"undefined slot",
+	"bad type",
+	"bad cast",
+	"wrong number of arguments",
+	"division by zero",
+	"I/O failure",
+	"misplaced jump",
+	"Go function failure",
+	"bad library",
+	"internal error",
+	"canceled",
+	"step limit exceeded",
+	"call depth limit exceeded",
+	"allocation limit exceeded",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// RuntimeError is an error that stopped a running program
+type RuntimeError struct {
+	Kind  ErrorKind
+	Msg   string
+	Pos   token.Pos    // position of the statement that failed
+	Stack []StackFrame // the calls that led to the failure, innermost first
+	Err   error        // the error from Go for GoFail, the context's for Canceled, or panicked with for Internal
+}
+
+// StackFrame is a function call in progress when a RuntimeError happened
+type StackFrame struct {
+	Func string    // name of the function or library being loaded, or "" for the program itself
+	Pos  token.Pos // position of the statement being run in the call
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Pos, e.Msg)
+}
+
+// Unwrap gives the error returned by a Go function or the context, if that's what stopped the program
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// maxTraceLines is how many lines Trace keeps, half from each end of the stack
+const maxTraceLines = 20
+
+// Trace formats the call stack, one line per frame, innermost first.  A run of
+// frames the same as the one before is one line saying how many more there are,
+// and of a stack that still takes more than maxTraceLines, only the innermost
+// and outermost frames are shown.
+func (e *RuntimeError) Trace() string {
+	var lines []string
+	for i := 0; i < len(e.Stack); {
+		f, n := e.Stack[i], 1
+		for i+n < len(e.Stack) && e.Stack[i+n] == f {
+			n++
+		}
+		name := f.Func
+		if name == "" {
+			name = "HAI"
+		}
+		lines = append(lines, fmt.Sprintf("\tin %s at %v\n", name, f.Pos))
+		if n > 1 {
+			lines = append(lines, fmt.Sprintf("\t... %d more\n", n-1))
+		}
+		i += n
+	}
+	if len(lines) > maxTraceLines {
+		skipped := fmt.Sprintf("\t... %d more lines\n", len(lines)-maxTraceLines)
+		lines = append(append(lines[:maxTraceLines/2:maxTraceLines/2], skipped), lines[len(lines)-maxTraceLines/2:]...)
+	}
+	return strings.Join(lines, "")
+}
+
+// raise stops the program with a RuntimeError.
+// Its position and stack are filled in when the interpreter recovers it.
+func raise(kind ErrorKind, format string, args ...interface{}) {
+	panic(&RuntimeError{Kind: kind, Msg: fmt.Sprintf(format, args...)})
+}
+
+// typeName is the Lolcode name for the type of a value
+func typeName(x interface{}) string {
+	switch x.(type) {
+	case nil:
+		return "NOOB"
+	case bool:
+		return "TROOF"
+	case int64:
+		return "NUMBR"
+	case float64:
+		return "NUMBAR"
+	case string:
+		return "YARN"
+	case *Bukkit:
+		return "BUKKIT"
+	case *function:
+		return "FUNKSHUN"
+	default:
+		return fmt.Sprintf("%T", x)
+	}
+}
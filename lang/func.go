@@ -0,0 +1,182 @@
+package lang
+
+import (
+	"fmt"
+	"lol/vm"
+)
+
+// A function is defined by HOW IZ I, or registered from Go, and called by I IZ
+type function struct {
+	name   string
+	nargs  int
+	params []string
+	body   []statement
+	code   *vm.Func // set instead of body when compiled to bytecode
+	goFunc GoFunc   // set instead of params and body for a function registered from Go
+	method bool     // in a slot of a BUKKIT, to be called on it as ME
+
+	funcs map[string]*function // of the program or library that defined it, which it calls
+}
+
+// String names the function, wherever fmt prints one
+func (f *function) String() string {
+	return "FUNKSHUN " + f.name
+}
+
+// GoFunc is a Go function that Lolcode programs can call with I IZ.
+// It is given the values of the arguments as Get would return them,
+// and may return anything Set accepts.  Returning an error stops the program,
+// and so does a panic, such as a failed type assertion on an argument.
+// The function isn't given the context of the run, and a run waiting for
+// it to return can't be canceled until it does.
+type GoFunc func(args ...Value) (Value, error)
+
+// Register makes fn callable as the function name, with nargs arguments.
+// A function of the same name defined by HOW IZ I replaces it.
+func (in *Interpreter) Register(name string, nargs int, fn GoFunc) {
+	in.funcs[name] = &function{name: name, nargs: nargs, goFunc: fn}
+}
+
+// call runs the function in a frame of its own, which holds only its arguments and IT,
+// and ME for a method, which is called on the BUKKIT me.
+// The function returns the value given to FOUND YR, NOOB on GTFO,
+// or IT if it reaches the end of its body.
+func (f *function) call(me interface{}, args []interface{}, r *run) interface{} {
+	f.checkArgs(len(args))
+	if f.goFunc != nil {
+		return r.allocYarn(f.callGo(args))
+	}
+	if f.code != nil { // from a BUKKIT made by an interpreter WithBytecode
+		return f.callCode(me, args, r)
+	}
+	caller := r.top
+	ns := r.newFrame(f.name)
+	ns.frame.funcs = f.funcs
+	if f.method {
+		ns.declare("ME", me)
+	}
+	for i, p := range f.params {
+		ns.declare(p, args[i])
+	}
+	result := runBlock(f.body, ns)
+	r.top = caller
+	switch result {
+	case flowGTFO:
+		return nil
+	case flowFound:
+		return ns.frame.ret
+	}
+	return ns.getOrPanic("IT")
+}
+
+// definedIn gives the function defined by HOW IZ I or HOW IZ as it's run in frame:
+// one that calls the functions of that frame
+func (f *function) definedIn(fr *frame) *function {
+	def := *f
+	def.funcs = fr.funcs
+	return &def
+}
+
+func (f *function) checkArgs(n int) {
+	if n != f.nargs {
+		raise(BadArgCount, "%s expects %d arguments, got %d", f.name, f.nargs, n)
+	}
+}
+
+// callGo runs a function registered from Go and converts its result
+func (f *function) callGo(args []interface{}) interface{} {
+	for _, arg := range args {
+		if _, ok := toGo(arg, true); !ok {
+			raise(BadType, "%s can't be given a function", f.name)
+		}
+	}
+	result, err := f.runGo(args)
+	if err == nil {
+		result, err = fromGo(result)
+	}
+	if err != nil {
+		panic(&RuntimeError{Kind: GoFail, Msg: f.name + ": " + err.Error(), Err: err})
+	}
+	return result
+}
+
+// runGo calls the function registered from Go, and returns a panic in it as an error
+func (f *function) runGo(args []interface{}) (result Value, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if err, _ = rec.(error); err == nil {
+				err = fmt.Errorf("%v", rec)
+			}
+		}
+	}()
+	return f.goFunc(args...)
+}
+
+func howizI(args []interface{}) interface{} {
+	f := &function{name: args[1].(string), body: block(args[4])}
+	params, err := paramList(f.name, args[2])
+	if err != nil {
+		return err
+	}
+	f.params, f.nargs = params, len(params)
+	return statement(func(ns *namespace) flow {
+		ns.frame.funcs[f.name] = f.definedIn(ns.frame)
+		return flowNext
+	})
+}
+
+// paramList checks the parameters of HOW IZ I name for duplicates
+func paramList(name string, arg interface{}) ([]string, error) {
+	var params []string
+	if arg != nil {
+		for _, p := range arg.([]interface{}) {
+			p := p.(string)
+			for _, prev := range params {
+				if p == prev {
+					return nil, fmt.Errorf("Duplicate parameter %s in HOW IZ I %s", p, name)
+				}
+			}
+			params = append(params, p)
+		}
+	}
+	return params, nil
+}
+
+func foundYr(args []interface{}) interface{} {
+	e := args[1].(expr)
+	return statement(func(ns *namespace) flow {
+		ns.frame.ret = e(ns)
+		return flowFound
+	})
+}
+
+func iizCall(args []interface{}) interface{} {
+	fname := args[1].(varName)
+	var argExprs []expr
+	if args[2] != nil {
+		for _, a := range args[2].([]interface{}) {
+			argExprs = append(argExprs, a.(expr))
+		}
+	}
+	return expr(func(ns *namespace) interface{} {
+		name := fname.get(ns)
+		f, ok := ns.frame.funcs[name]
+		if !ok {
+			raise(UndefinedFunc, "Call to undefined function: %s", name)
+		}
+		vals := make([]interface{}, len(argExprs))
+		for i, a := range argExprs {
+			vals[i] = a(ns)
+		}
+		return f.call(nil, vals, ns.frame.run)
+	})
+}
+
+// yrMoar collects YR x AN YR y ... into a list
+func yrMoar(args []interface{}) interface{} {
+	return append([]interface{}{args[1]}, args[2].([]interface{})...)
+}
+
+func anYr(args []interface{}) interface{} {
+	return args[2]
+}
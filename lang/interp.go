@@ -0,0 +1,272 @@
+package lang
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"lol/parser"
+	"lol/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Interpreter compiles and runs Lolcode programs.  It holds the streams that
+// VISIBLE writes to and GIMMEH reads from, and the global variables, which
+// persist from one run to the next.  An Interpreter is not safe for concurrent use.
+type Interpreter struct {
+	stdout  io.Writer
+	stdin   *bufio.Reader
+	reading chan inputLine // a read for GIMMEH that a canceled run left going
+	funcs   map[string]*function
+	globals map[string]interface{}
+	libs    map[string]*Library
+	libPath []string
+	loaded  map[string]*module // libraries loaded so far, by name, or by path for files
+
+	maxSteps, maxDepth, maxAlloc int
+	bytecode                     bool
+}
+
+// Option configures an Interpreter
+type Option func(*Interpreter)
+
+// WithStdout sends the output of VISIBLE to w instead of os.Stdout
+func WithStdout(w io.Writer) Option {
+	return func(in *Interpreter) {
+		in.stdout = w
+	}
+}
+
+// WithStdin makes GIMMEH read lines from r instead of os.Stdin
+func WithStdin(r io.Reader) Option {
+	return func(in *Interpreter) {
+		in.stdin = bufio.NewReader(r)
+	}
+}
+
+// WithBytecode compiles programs to bytecode for the machine of package vm, instead
+// of to Go closures.  Programs behave the same either way, but the bytecode
+// is more compact and runs math and comparisons on numbers without allocating;
+// calls still allocate a frame each, and YARNs and BUKKITs as much as in closures.
+func WithBytecode() Option {
+	return func(in *Interpreter) {
+		in.bytecode = true
+	}
+}
+
+// New constructs an Interpreter, by default connected to os.Stdout and os.Stdin
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{
+		funcs:   make(map[string]*function),
+		globals: map[string]interface{}{"IT": nil},
+		libs:    map[string]*Library{"STDIO": NewLibrary()},
+		loaded:  make(map[string]*module),
+	}
+	for _, opt := range opts {
+		opt(in)
+	}
+	if in.stdout == nil {
+		in.stdout = os.Stdout
+	}
+	if in.stdin == nil {
+		in.stdin = bufio.NewReader(os.Stdin)
+	}
+	if in.maxDepth <= 0 {
+		in.maxDepth = DefaultMaxDepth
+	}
+	return in
+}
+
+// Compile parses a whole program, from HAI to KTHXBYE, for this interpreter to run.
+// The version HAI declares, or DefaultVersion, chooses the dialect it's parsed with.
+// Syntax errors are returned as a parser.ErrorList.
+func (in *Interpreter) Compile(r io.Reader) (*Program, error) {
+	return in.CompileFile("", r)
+}
+
+// CompileFile is like Compile, but positions in syntax and runtime errors name the file
+func (in *Interpreter) CompileFile(name string, r io.Reader) (*Program, error) {
+	prog, err := in.compile(name, r, Source, token.EOFPhrase)
+	if err != nil {
+		return nil, err
+	}
+	p := prog.(*Program)
+	p.in, p.file = in, name
+	return p, nil
+}
+
+// CompileStatements parses statements without HAI and KTHXBYE around them, such as
+// the lines typed at a prompt, into a program that runs them.  They may use
+// everything in LatestVersion.
+func (in *Interpreter) CompileStatements(r io.Reader) (*Program, error) {
+	body, err := in.compile("", r, Block, "statement")
+	if err != nil {
+		return nil, err
+	}
+	if in.bytecode {
+		return &Program{code: assemble("", nil, blockCode(body)), in: in}, nil
+	}
+	return &Program{body: block(body), in: in}, nil
+}
+
+// compile parses node start, which must take up all of r
+func (in *Interpreter) compile(name string, r io.Reader, start int, expected string) (interface{}, error) {
+	lexed := make(chan token.Token, 100)
+	go token.EmitFileTokens(name, bufio.NewReader(r), lexed)
+	var tokens <-chan token.Token = lexed
+	defer func() {
+		for range tokens { // let the lexer finish
+		}
+	}()
+	version := LatestVersion
+	if start == Source {
+		var head []token.Token
+		version, head = readVersion(tokens)
+		if _, ok := Dialects[version]; !ok {
+			return nil, parser.ErrorList{{
+				Pos: head[1].Pos,
+				Msg: fmt.Sprintf("Unsupported version HAI %s, expected %s", version, versionList()),
+			}}
+		}
+		tokens = replay(head, tokens)
+	}
+	d := Dialects[version]
+	if in.bytecode {
+		d = bytecodeDialects[version]
+	}
+	cur, val, err := d.Parse(start, tokens)
+	if err != nil {
+		return nil, err
+	}
+	if cur.Type != token.EOF {
+		return nil, parser.ErrorList{{
+			Pos:      cur.Pos,
+			Found:    *cur,
+			Expected: []string{expected},
+		}}
+	}
+	return val, nil
+}
+
+// readVersion reads the tokens of the HAI line up to the version it declares, and
+// returns the version and the tokens it read
+func readVersion(tokens <-chan token.Token) (string, []token.Token) {
+	head := []token.Token{<-tokens}
+	if head[0].Type != token.TokHAI {
+		return DefaultVersion, head // not a program, as the parser will explain
+	}
+	head = append(head, <-tokens)
+	switch v := head[1].Value.(type) {
+	case float64:
+		if head[1].Type == token.Literal {
+			return strconv.FormatFloat(v, 'f', -1, 64), head
+		}
+	case int64:
+		if head[1].Type == token.Literal {
+			return strconv.FormatInt(v, 10), head
+		}
+	}
+	return DefaultVersion, head
+}
+
+// replay sends the tokens of head, then the rest
+func replay(head []token.Token, rest <-chan token.Token) <-chan token.Token {
+	tokens := make(chan token.Token, cap(rest))
+	go func() {
+		for _, t := range head {
+			tokens <- t
+		}
+		for t := range rest {
+			tokens <- t
+		}
+		close(tokens)
+	}()
+	return tokens
+}
+
+// versionList lists the versions that have dialects, as "1.2 or 1.3"
+func versionList() string {
+	versions := make([]string, 0, len(Dialects))
+	for v := range Dialects {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	return strings.Join(versions[:len(versions)-1], ", ") + " or " + versions[len(versions)-1]
+}
+
+// Run executes the program with the globals of the interpreter that compiled it.
+// A runtime error stops execution and is returned as a *RuntimeError.
+// If ctx is already done, the program isn't started and ctx.Err() is returned;
+// if it's done while the program runs, the program stops with a Canceled RuntimeError.
+func (p *Program) Run(ctx context.Context) error {
+	in := p.in
+	if in == nil {
+		in = New()
+	}
+	return in.run(ctx, p)
+}
+
+// Get returns the value of a global variable as a Go value: NOOB is nil, a TROOF
+// is a bool, a NUMBR an int64, a NUMBAR a float64, a YARN a string and a BUKKIT
+// a *Bukkit.  A variable holding a function, taken from a slot, isn't given.
+func (in *Interpreter) Get(name string) (Value, bool) {
+	val, ok := in.globals[name]
+	return toGo(val, ok)
+}
+
+// Set declares a global variable, or changes the value of an existing one, so that
+// programs can use it without I HAS A.  val may be nil, or of any Go type with a
+// bool, integer, floating-point or string kind, or a *Bukkit; integers become NUMBRs
+// and floating-point numbers NUMBARs.
+func (in *Interpreter) Set(name string, val interface{}) error {
+	v, err := fromGo(val)
+	if err != nil {
+		return err
+	}
+	in.globals[name] = v
+	return nil
+}
+
+func (in *Interpreter) run(ctx context.Context, p *Program) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := &run{Interpreter: in, ctx: ctx}
+	if p.file != "" { // so that CAN HAS can't run the program again
+		r.loading = []loading{{key: moduleKey(p.file), name: filepath.Base(p.file)}}
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			rtErr, ok := rec.(*RuntimeError)
+			if !ok { // rather than crash the host process
+				rtErr = &RuntimeError{Kind: Internal, Msg: fmt.Sprint("Internal error: ", rec)}
+				rtErr.Err, _ = rec.(error)
+			}
+			rtErr.Pos, rtErr.Stack = r.top.pos, r.stack()
+			err = rtErr
+		}
+	}()
+	if p.code != nil {
+		r.pushFrame("")
+		r.runCode(p.code, in.globals)
+		return nil
+	}
+	ns := r.newFrame("")
+	ns.vars = in.globals
+	r.runTop(p.body, ns)
+	return nil
+}
+
+// runTop runs the top level of a program
+func (r *run) runTop(body []statement, ns *namespace) {
+	switch runBlock(body, ns) {
+	case flowGTFO:
+		raise(BadJump, "GTFO outside of a switch, loop or function")
+	case flowFound:
+		raise(BadJump, "FOUND YR outside of a function")
+	}
+}
@@ -2,13 +2,24 @@ package lang
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"lol/parser"
 	"lol/token"
+	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func ns() *namespace {
-	return &namespace{vars: make(map[string]interface{})}
+	r := &run{Interpreter: New(), ctx: context.Background()}
+	return r.newFrame("")
 }
 
 func tokenChan(code string) <-chan token.Token {
@@ -67,11 +78,13 @@ func TestBasicExpressions(t *testing.T) {
 		{`SMALLR OF "12" AN 0.0`, f(0)},
 		{`SMOOSH 1 2 BAR AN "LOL"`, "125LOL"},
 		{`SUM OF SMOOSH 4 AN 5 AN 6 MKAY AN 123`, i(579)},
+		{`"FOO IZ :{FOO}:) BAR IZ :{BAR}"`, "FOO IZ -10\n BAR IZ 5"},
+		{`":{BAR}:{BAR}"`, "55"},
 	}
 	for _, tc := range testCases {
-		_, ex, ok := D.Parse(Expr, tokenChan(tc.code+"\n"))
-		if !ok {
-			t.Fatalf("Parse failed")
+		_, ex, err := D.Parse(Expr, tokenChan(tc.code+"\n"))
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
 		}
 		res := ex.(expr)(ns)
 		if res != tc.expectedVal {
@@ -94,14 +107,23 @@ func TestBasicStatments(t *testing.T) {
 		{"I HAS A FISH ITZ WIN", "FISH", true},
 		{"FOO R \"hello\"", "FOO", "hello"},
 		{"BAR IS NOW A NUMBAR", "BAR", float64(5)},
+		{"FOO IS NOW A YARN", "FOO", "-10"},
+		{"FOO IS NOW A TROOF", "FOO", true},
+		{"FOO IS NOW A NOOB", "FOO", nil},
+		{"FOO R MAEK BAR NUMBR", "FOO", int64(5)},
+		{"FOO R MAEK FOO YARN", "FOO", "-10"},
+		{"FOO R MAEK FOO A NUMBAR", "FOO", float64(-10)},
+		{"FOO R MAEK BAR TROOF", "FOO", true},
+		{"FOO R MAEK BAR NOOB", "FOO", nil},
+		{"FOO R NOOB", "FOO", nil},
 	}
 	for _, tc := range testCases {
 		ns := ns()
 		ns.vars["FOO"] = int64(-10)
 		ns.vars["BAR"] = "5"
-		_, ex, ok := D.Parse(Statement, tokenChan(tc.code+"\n"))
-		if !ok {
-			t.Fatalf("Parse failed")
+		_, ex, err := D.Parse(Statement, tokenChan(tc.code+"\n"))
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
 		}
 		ex.(statement)(ns)
 		res, ok := ns.vars[tc.variable]
@@ -113,3 +135,1324 @@ func TestBasicStatments(t *testing.T) {
 		}
 	}
 }
+
+func TestProgram(t *testing.T) {
+	code := `HAI 1.2
+I HAS A FISH ITZ 5
+FISH R SUM OF FISH AN 2
+FISH
+KTHXBYE
+`
+	_, prog, err := D.Parse(Source, tokenChan(code))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	ns := ns()
+	runBlock(prog.(*Program).body, ns)
+	if res := ns.vars["IT"]; res != int64(7) {
+		t.Fatalf("IT contained %v %T, expected 7", res, res)
+	}
+
+	_, prog, err = D.Parse(Source, tokenChan("HAI 1.2\nSUM OF NOOB AN 1\nKTHXBYE"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	err = prog.(*Program).Run(context.Background())
+	if err == nil {
+		t.Fatalf("Expected runtime error")
+	}
+	if !strings.HasPrefix(err.Error(), "2:1: ") {
+		t.Fatalf("Runtime error %q does not start with its position", err)
+	}
+}
+
+// backends are the options for compiling to each backend, which tests that run
+// programs check behave the same
+var backends = []struct {
+	name string
+	opt  Option
+}{
+	{"closures", func(*Interpreter) {}},
+	{"bytecode", WithBytecode()},
+}
+
+// forBackends runs test as a subtest for each backend, given the option that selects it
+func forBackends(t *testing.T, test func(t *testing.T, opt Option)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) { test(t, b.opt) })
+	}
+}
+
+// runProgram runs a whole program with the given input on each backend, and returns
+// what it printed, after checking that every backend printed and failed alike
+func runProgram(t *testing.T, code string, input string) (string, error) {
+	t.Helper()
+	var outs []string
+	var errs []error
+	for _, b := range backends {
+		var out bytes.Buffer
+		in := New(b.opt, WithStdout(&out), WithStdin(strings.NewReader(input)))
+		prog, err := in.Compile(strings.NewReader(code))
+		if err != nil {
+			t.Fatalf("Compile failed with %s: %v", b.name, err)
+		}
+		err = prog.Run(context.Background())
+		outs, errs = append(outs, out.String()), append(errs, err)
+	}
+	for i := 1; i < len(backends); i++ {
+		if outs[i] != outs[0] || fmt.Sprint(errs[i]) != fmt.Sprint(errs[0]) {
+			t.Fatalf("%s\n%s printed %q and returned %v, but %s printed %q and returned %v", code,
+				backends[0].name, outs[0], errs[0], backends[i].name, outs[i], errs[i])
+		}
+		if rtErr, ok := errs[i].(*RuntimeError); ok && rtErr.Trace() != errs[0].(*RuntimeError).Trace() {
+			t.Fatalf("%s\n%s stack:\n%s%s stack:\n%s", code, backends[0].name,
+				errs[0].(*RuntimeError).Trace(), backends[i].name, rtErr.Trace())
+		}
+	}
+	return outs[0], errs[0]
+}
+
+func TestVisibleGimmeh(t *testing.T) {
+	code := `HAI 1.2
+I HAS A NAME
+GIMMEH NAME
+VISIBLE "HAI " NAME "!"
+VISIBLE 1 AN 2.5 WIN!
+VISIBLE "..."!
+I HAS A NUM, GIMMEH NUM
+VISIBLE SUM OF NUM AN 1
+GIMMEH NAME, VISIBLE "[" NAME "]"
+KTHXBYE
+`
+	out, err := runProgram(t, code, "CEILING CAT\r\n41\n")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if expected := "HAI CEILING CAT!\n12.50WIN...42\n[]\n"; out != expected {
+		t.Fatalf("Program printed %q, expected %q", out, expected)
+	}
+
+	if _, err := runProgram(t, "HAI 1.2\nVISIBLE NOOB\nKTHXBYE\n", ""); err == nil {
+		t.Fatalf("Expected error printing NOOB")
+	}
+}
+
+func TestOrly(t *testing.T) {
+	type testCase struct {
+		n        string
+		expected string
+	}
+	testCases := []testCase{
+		{"1", "ONE\n"},
+		{"2", "TWO\n"},
+		{"3", "THREE\nSTILL THREE\n"},
+		{"4", "LOTS\n"},
+	}
+	for _, tc := range testCases {
+		code := `HAI 1.2
+I HAS A N ITZ ` + tc.n + `
+BOTH SAEM N AN 1, O RLY?
+	YA RLY, VISIBLE "ONE"
+	MEBBE BOTH SAEM N AN 2
+		VISIBLE "TWO"
+	MEBBE BOTH SAEM N AN 3
+		VISIBLE "THREE"
+		VISIBLE "STILL THREE"
+	NO WAI
+		VISIBLE "LOTS"
+OIC
+KTHXBYE
+`
+		out, err := runProgram(t, code, "")
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if out != tc.expected {
+			t.Fatalf("N=%s printed %q, expected %q", tc.n, out, tc.expected)
+		}
+	}
+
+	// NO WAI is optional, and IT is left alone by MEBBE
+	code := `HAI 1.2
+FAIL
+O RLY?
+YA RLY
+	VISIBLE "NOPE"
+MEBBE FAIL
+	VISIBLE "NOPE"
+OIC
+VISIBLE IT
+KTHXBYE
+`
+	out, err := runProgram(t, code, "")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "FAIL\n" {
+		t.Fatalf("Program printed %q", out)
+	}
+}
+
+func TestWtf(t *testing.T) {
+	type testCase struct {
+		color    string
+		expected string
+	}
+	testCases := []testCase{
+		{`"R"`, "RED FISH\n"},
+		{`"Y"`, "YELLOW FISH\nGREEN FISH\n"},
+		{`"G"`, "GREEN FISH\n"},
+		{`"B"`, "BLUE FISH\nFISH IS RED\n"},
+		{`"P"`, "FISH IS RED\n"},
+		{`1`, "FISH IS RED\n"},
+		{`2`, "YELLOW FISH\nGREEN FISH\n"},
+	}
+	for _, tc := range testCases {
+		code := `HAI 1.2
+` + tc.color + `
+WTF?
+	OMG "R"
+		VISIBLE "RED FISH"
+		GTFO
+	OMG "Y"
+	OMG 2
+		VISIBLE "YELLOW FISH"
+	OMG "G"
+		VISIBLE "GREEN FISH"
+		GTFO
+	OMG "B"
+		VISIBLE "BLUE FISH"
+	OMGWTF
+		VISIBLE "FISH IS RED"
+OIC
+KTHXBYE
+`
+		out, err := runProgram(t, code, "")
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if out != tc.expected {
+			t.Fatalf("%s printed %q, expected %q", tc.color, out, tc.expected)
+		}
+	}
+
+	code := "HAI 1.2\nWTF?\nOMG 1\nGTFO\nOMG 1.0\nGTFO\nOIC\nKTHXBYE\n"
+	if _, _, err := D.Parse(Source, tokenChan(code)); err == nil {
+		t.Fatalf("Expected duplicate OMG labels to be rejected")
+	}
+}
+
+func TestLoops(t *testing.T) {
+	type testCase struct {
+		code     string
+		expected string
+	}
+	testCases := []testCase{
+		{`IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 3
+	VISIBLE N
+IM OUTTA YR LOOP`, "0\n1\n2\n"},
+		{`IM IN YR LOOP NERFIN YR N WILE DIFFRINT N AN -2
+	VISIBLE N
+IM OUTTA YR LOOP`, "0\n-1\n"},
+		{`I HAS A N ITZ "OUTER"
+IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 2
+	IM IN YR INNER UPPIN YR M
+		BOTH SAEM M AN 2, O RLY?
+			YA RLY, GTFO
+		OIC
+		VISIBLE N M!
+	IM OUTTA YR INNER
+	VISIBLE ""
+IM OUTTA YR LOOP
+VISIBLE N`, "0001\n1011\nOUTER\n"},
+		{`I HAS A COUNT ITZ 0
+IM IN YR LOOP
+	COUNT R SUM OF COUNT AN 1
+	DIFFRINT COUNT AN 4, O RLY?
+		YA RLY
+		NO WAI, GTFO
+	OIC
+IM OUTTA YR LOOP
+VISIBLE COUNT`, "4\n"},
+	}
+	for _, tc := range testCases {
+		out, err := runProgram(t, "HAI 1.2\n"+tc.code+"\nKTHXBYE\n", "")
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if out != tc.expected {
+			t.Fatalf("%s\nprinted %q, expected %q", tc.code, out, tc.expected)
+		}
+	}
+
+	code := "HAI 1.2\nIM IN YR LOOP\nGTFO\nIM OUTTA YR LOOOP\nKTHXBYE\n"
+	if _, _, err := D.Parse(Source, tokenChan(code)); err == nil {
+		t.Fatalf("Expected mismatched loop labels to be rejected")
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	type testCase struct {
+		code     string
+		expected string
+	}
+	testCases := []testCase{
+		{`HOW IZ I ADD YR X AN YR Y
+	FOUND YR SUM OF X AN Y
+IF U SAY SO
+VISIBLE I IZ ADD YR 1 AN YR SUM OF 2 AN 3 MKAY`, "6\n"},
+		// implicit return of IT, and recursion
+		{`HOW IZ I FACT YR N
+	BOTH SAEM N AN 0, O RLY?
+		YA RLY, 1
+		NO WAI, PRODUKT OF N AN I IZ FACT YR DIFF OF N AN 1 MKAY
+	OIC
+IF U SAY SO
+VISIBLE I IZ FACT YR 5 MKAY`, "120\n"},
+		// GTFO returns NOOB, after breaking out of any loop
+		{`HOW IZ I NOPE
+	IM IN YR LOOP UPPIN YR N
+		BOTH SAEM N AN 2, O RLY?
+			YA RLY, GTFO
+		OIC
+	IM OUTTA YR LOOP
+	VISIBLE "OUTTA LOOP"
+	GTFO
+IF U SAY SO
+I IZ NOPE MKAY
+VISIBLE MAEK IT A YARN "."`, "OUTTA LOOP\n.\n"},
+		// FOUND YR inside a loop returns from the function
+		{`HOW IZ I FIRSTBIG YR LIMIT
+	IM IN YR LOOP UPPIN YR N
+		BOTH SAEM N AN BIGGR OF N AN LIMIT, O RLY?
+			YA RLY, FOUND YR N
+		OIC
+	IM OUTTA YR LOOP
+IF U SAY SO
+VISIBLE I IZ FIRSTBIG YR 3 MKAY`, "3\n"},
+		// functions can't see the caller's variables, and set their own IT
+		{`I HAS A X ITZ "CALLER"
+HOW IZ I SHADOW YR X
+	I HAS A Y ITZ X
+	Y
+IF U SAY SO
+"MAIN"
+VISIBLE I IZ SHADOW YR 5 MKAY " " X " " IT`, "5 CALLER MAIN\n"},
+	}
+	for _, tc := range testCases {
+		out, err := runProgram(t, "HAI 1.2\n"+tc.code+"\nKTHXBYE\n", "")
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if out != tc.expected {
+			t.Fatalf("%s\nprinted %q, expected %q", tc.code, out, tc.expected)
+		}
+	}
+
+	failures := []string{
+		"I IZ UNDEFINED MKAY",
+		"HOW IZ I F YR X\nIF U SAY SO\nI IZ F MKAY",
+		"I HAS A X ITZ 1\nHOW IZ I F\nX\nIF U SAY SO\nI IZ F MKAY",
+		"FOUND YR 1",
+	}
+	for _, code := range failures {
+		if _, err := runProgram(t, "HAI 1.2\n"+code+"\nKTHXBYE\n", ""); err == nil {
+			t.Fatalf("%s\nExpected runtime error", code)
+		}
+	}
+}
+
+func TestBukkits(t *testing.T) {
+	type testCase struct {
+		code     string
+		expected string
+	}
+	testCases := []testCase{
+		{`I HAS A CAT ITZ A BUKKIT
+CAT HAS A NAME ITZ "TIBBLES"
+CAT HAS A LIVES
+CAT'Z LIVES R 9
+CAT'Z LIVES R DIFF OF CAT'Z LIVES AN 1
+CAT'Z LIVES IS NOW A YARN
+VISIBLE CAT'Z NAME " " CAT'Z LIVES " " MAEK CAT'Z LIVES A NUMBAR`, "TIBBLES 8 8.00\n"},
+		// SRS computes the name of a slot, so a BUKKIT is an array too
+		{`I HAS A LIST ITZ A BUKKIT
+IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 3
+	LIST HAS A SRS N ITZ PRODUKT OF N AN N
+IM OUTTA YR LOOP
+LIST'Z SRS 1 R "ONE"
+VISIBLE LIST'Z SRS 0 " " LIST'Z SRS "1" " " LIST'Z SRS SUM OF 1 AN 1`, "0 ONE 4\n"},
+		// BUKKITs nest, and are shared rather than copied
+		{`I HAS A OUTER ITZ A BUKKIT
+OUTER HAS A INNER ITZ A BUKKIT
+OUTER'Z INNER HAS A X ITZ 1
+I HAS A SAME ITZ OUTER'Z INNER
+SAME'Z X R 2
+VISIBLE OUTER'Z INNER'Z X
+BOTH SAEM SAME AN OUTER'Z INNER, VISIBLE IT
+BOTH SAEM SAME AN OUTER, VISIBLE IT`, "2\nWIN\nFAIL\n"},
+		// a slot function sees its BUKKIT as ME, and not the caller's variables
+		{`I HAS A X ITZ "GLOBAL"
+I HAS A COUNTER ITZ A BUKKIT
+COUNTER HAS A X ITZ 0
+HOW IZ COUNTER ADD YR N
+	ME'Z X R SUM OF ME'Z X AN N
+	FOUND YR ME'Z X
+IF U SAY SO
+COUNTER IZ ADD YR 2 MKAY
+VISIBLE COUNTER IZ ADD YR 3 MKAY " " IT " " X`, "5 2 GLOBAL\n"},
+		// LIEK A inherits slots, until they're assigned or declared
+		{`I HAS A ANIMAL ITZ A BUKKIT
+ANIMAL HAS A SOUND ITZ "..."
+ANIMAL HAS A LEGS ITZ 4
+HOW IZ ANIMAL SPEAK
+	FOUND YR SMOOSH ME'Z SOUND AN "!" MKAY
+IF U SAY SO
+I HAS A DOG ITZ LIEK A ANIMAL
+DOG'Z SOUND R "WOOF"
+I HAS A BIRD ITZ LIEK A ANIMAL
+BIRD HAS A LEGS ITZ 2
+ANIMAL'Z LEGS R 3
+VISIBLE DOG IZ SPEAK MKAY " " ANIMAL IZ SPEAK MKAY
+VISIBLE DOG'Z LEGS " " BIRD'Z LEGS`, "WOOF! ...!\n3 2\n"},
+		// a BUKKIT is WIN once it or its parent has slots, and MAEK A BUKKIT of NOOB makes one
+		{`I HAS A B ITZ A BUKKIT
+I HAS A C ITZ LIEK A B
+VISIBLE MAEK B A TROOF " " MAEK C A TROOF
+B HAS A X
+VISIBLE MAEK B A TROOF " " MAEK C A TROOF
+I HAS A D
+D IS NOW A BUKKIT
+D HAS A Y ITZ WIN
+BOTH SAEM MAEK D A BUKKIT AN D, VISIBLE IT " " D'Z Y`, "FAIL FAIL\nWIN WIN\nWIN WIN\n"},
+	}
+	for _, tc := range testCases {
+		out, err := runProgram(t, "HAI 1.3\n"+tc.code+"\nKTHXBYE\n", "")
+		if err != nil {
+			t.Fatalf("%s\nRun failed: %v", tc.code, err)
+		}
+		if out != tc.expected {
+			t.Fatalf("%s\nprinted %q, expected %q", tc.code, out, tc.expected)
+		}
+	}
+
+	for _, b := range backends {
+		in := New(b.opt, WithStdout(io.Discard))
+		cat := NewBukkit()
+		if err := cat.Set("LIVES", 9); err != nil {
+			t.Fatalf("Set LIVES: %v", err)
+		}
+		if err := in.Set("CAT", cat); err != nil {
+			t.Fatalf("Set CAT: %v", err)
+		}
+		prog, err := in.Compile(strings.NewReader("HAI 1.3\nCAT HAS A NAME ITZ \"TIBBLES\"\nCAT'Z LIVES R 8\nKTHXBYE\n"))
+		if err != nil {
+			t.Fatalf("Compile failed with %s: %v", b.name, err)
+		}
+		if err := prog.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed with %s: %v", b.name, err)
+		}
+		lives, _ := cat.Get("LIVES")
+		if slots := cat.Slots(); lives != int64(8) || len(slots) != 2 || slots[1] != "NAME" {
+			t.Errorf("%s left CAT with slots %q and LIVES %v", b.name, slots, lives)
+		}
+
+		// functions in slots stay out of Go's reach
+		in.Register("TAKE", 1, func(args ...Value) (Value, error) { return nil, nil })
+		prog, err = in.Compile(strings.NewReader("HAI 1.3\nHOW IZ CAT PURR\n\tFOUND YR 1\nIF U SAY SO\n" +
+			"I HAS A P ITZ CAT'Z PURR\nCAT'Z PURR\nKTHXBYE\n"))
+		if err != nil {
+			t.Fatalf("Compile failed with %s: %v", b.name, err)
+		}
+		if err := prog.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed with %s: %v", b.name, err)
+		}
+		for _, name := range []string{"IT", "P"} {
+			if val, ok := in.Get(name); ok || val != nil {
+				t.Errorf("%s gave %s as %v", b.name, name, val)
+			}
+		}
+		if val, ok := cat.Get("PURR"); ok || val != nil {
+			t.Errorf("%s gave CAT'Z PURR as %v", b.name, val)
+		}
+		prog, err = in.Compile(strings.NewReader("HAI 1.3\nI IZ TAKE YR CAT'Z PURR MKAY\nKTHXBYE\n"))
+		if err != nil {
+			t.Fatalf("Compile failed with %s: %v", b.name, err)
+		}
+		err = prog.Run(context.Background())
+		if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != BadType || err.Error() != "2:1: TAKE can't be given a function" {
+			t.Errorf("Expected %s to refuse a function to Go, got %v", b.name, err)
+		}
+	}
+
+	// a BUKKIT keeps its methods when it's passed to an interpreter of the other backend
+	for _, from := range backends {
+		for _, to := range backends {
+			maker := New(from.opt, WithStdout(io.Discard))
+			prog, err := maker.Compile(strings.NewReader("HAI 1.3\nHOW IZ I TWICE YR N\n\tFOUND YR PRODUKT OF N AN 2\n" +
+				"IF U SAY SO\nI HAS A CAT ITZ A BUKKIT\nCAT HAS A LIVES ITZ 9\nHOW IZ CAT PURR YR N\n" +
+				"\tFOUND YR SUM OF ME'Z LIVES AN I IZ TWICE YR N MKAY\nIF U SAY SO\nKTHXBYE\n"))
+			if err != nil {
+				t.Fatalf("Compile failed with %s: %v", from.name, err)
+			}
+			if err := prog.Run(context.Background()); err != nil {
+				t.Fatalf("Run failed with %s: %v", from.name, err)
+			}
+			cat, _ := maker.Get("CAT")
+			var out bytes.Buffer
+			in := New(to.opt, WithStdout(&out))
+			if err := in.Set("CAT", cat); err != nil {
+				t.Fatalf("Set CAT: %v", err)
+			}
+			prog, err = in.Compile(strings.NewReader("HAI 1.3\nVISIBLE CAT IZ PURR YR 2 MKAY\nKTHXBYE\n"))
+			if err != nil {
+				t.Fatalf("Compile failed with %s: %v", to.name, err)
+			}
+			if err := prog.Run(context.Background()); err != nil || out.String() != "13\n" {
+				t.Errorf("PURR from %s run with %s printed %q, error %v", from.name, to.name, out.String(), err)
+			}
+		}
+	}
+
+	kinds := map[string]ErrorKind{
+		"I HAS A B ITZ A BUKKIT\nB'Z X":                                     UndefinedSlot,
+		"I HAS A B ITZ A BUKKIT\nB'Z X R 1":                                 UndefinedSlot,
+		"I HAS A B ITZ A BUKKIT\nB IZ F MKAY":                               UndefinedSlot,
+		"I HAS A B ITZ 1\nB'Z X":                                            BadType,
+		"I HAS A B ITZ A BUKKIT\nB HAS A F\nB IZ F MKAY":                    BadType,
+		"I HAS A B ITZ A BUKKIT\nVISIBLE B":                                 BadCast,
+		"I HAS A B ITZ A BUKKIT\nSUM OF B AN 1":                             BadType,
+		"I HAS A B ITZ 1\nB IS NOW A BUKKIT":                                BadCast,
+		"I HAS A B ITZ LIEK A 1":                                            BadType,
+		"I HAS A B ITZ A BUKKIT\nHOW IZ B F\nIF U SAY SO\nB IZ F YR 1 MKAY": BadArgCount,
+	}
+	for code, kind := range kinds {
+		_, err := runProgram(t, "HAI 1.3\n"+code+"\nKTHXBYE\n", "")
+		if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != kind {
+			t.Fatalf("%s\nExpected %v error, got %v", code, kind, err)
+		}
+	}
+}
+
+func TestSrs(t *testing.T) {
+	code := `HAI 1.3
+I HAS A PREFIX ITZ "CONFIG_"
+I HAS A SRS SMOOSH PREFIX AN "PORT" MKAY ITZ 80
+I HAS A SRS "CONFIG_HOST"
+SRS "CONFIG_HOST" R "LOCALHOST"
+SRS SMOOSH PREFIX AN "PORT" MKAY R SUM OF SRS "CONFIG_PORT" AN 8000
+SRS "CONFIG_PORT" IS NOW A YARN
+I HAS A SRS 1 ITZ "ONE"
+GIMMEH SRS SMOOSH PREFIX AN "HOST" MKAY
+HOW IZ I CONFIG YR KEY
+	FOUND YR SMOOSH "CONFIG_" AN KEY MKAY
+IF U SAY SO
+HOW IZ I PORT
+	FOUND YR 8080
+IF U SAY SO
+I HAS A NAME ITZ "PORT"
+VISIBLE CONFIG_HOST "::" CONFIG_PORT " " SRS I IZ CONFIG YR "PORT" MKAY " " SRS "1"
+VISIBLE I IZ SRS NAME MKAY " " MAEK SRS "CONFIG_PORT" A NUMBR
+I HAS A CAT ITZ A BUKKIT
+CAT HAS A NAME ITZ "TIBBLES"
+HOW IZ SRS "CAT" SPEAK
+	FOUND YR ME'Z NAME
+IF U SAY SO
+VISIBLE SRS "CAT" IZ SPEAK MKAY
+SRS "CAT" HAS A LIVES ITZ 9
+VISIBLE CAT'Z LIVES
+KTHXBYE
+`
+	out, err := runProgram(t, code, "EXAMPLE.COM\n")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if expected := "EXAMPLE.COM:8080 8080 ONE\n8080 8080\nTIBBLES\n9\n"; out != expected {
+		t.Fatalf("Program printed %q, expected %q", out, expected)
+	}
+
+	kinds := map[string]ErrorKind{
+		`SRS "X"`:                       UndefinedVar,
+		`SRS "X" R 1`:                   UndefinedVar,
+		`GIMMEH SRS "X"`:                UndefinedVar,
+		`I IZ SRS "F" MKAY`:             UndefinedFunc,
+		"I HAS A B ITZ A BUKKIT\nSRS B": BadCast,
+	}
+	for code, kind := range kinds {
+		_, err := runProgram(t, "HAI 1.3\n"+code+"\nKTHXBYE\n", "")
+		if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != kind {
+			t.Fatalf("%s\nExpected %v error, got %v", code, kind, err)
+		}
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"MATH.lol": "HAI 1.2\nI HAS A PI ITZ 3.14\nHOW IZ I SQUARE YR X\n\tFOUND YR PRODUKT OF X AN X\n" +
+			"IF U SAY SO\nVISIBLE \"LOADING MATH\"\nKTHXBYE\n",
+		"shapes.lol": "HAI 1.2\nCAN HAS MATH?\nI HAS A UNIT ITZ I IZ SQUARE YR 2 MKAY\nKTHXBYE\n",
+		"loopa.lol":  "HAI 1.2\nCAN HAS LOOPB?\nKTHXBYE\n",
+		"loopb.lol":  "HAI 1.2\nCAN HAS LOOPA?\nKTHXBYE\n",
+		"broken.lol": "HAI 1.2\nVISIBLE\nKTHXBYE\n",
+		"crash.lol":  "HAI 1.2\nVISIBLE QUOSHUNT OF 1 AN 0\nKTHXBYE\n",
+		"main.lol":   "HAI 1.2\nVISIBLE \"MAIN START\"\nCAN HAS BACK?\nKTHXBYE\n",
+		"back.lol":   "HAI 1.2\nCAN HAS MAIN?\nKTHXBYE\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	greeter := NewLibrary()
+	greeter.Register("GREET", 1, func(args ...Value) (Value, error) {
+		return "O HAI " + args[0].(string), nil
+	})
+	if err := greeter.Set("GREETING", "O HAI"); err != nil {
+		t.Fatalf("Set GREETING: %v", err)
+	}
+
+	for _, b := range backends {
+		var out bytes.Buffer
+		in := New(b.opt, WithStdout(&out), WithLibraryPath(filepath.Join(dir, "nothing"), dir))
+		in.AddLibrary("GREETER", greeter)
+		run := func(code string) error {
+			prog, err := in.Compile(strings.NewReader("HAI 1.2\n" + code + "\nKTHXBYE\n"))
+			if err != nil {
+				t.Fatalf("Compile failed with %s: %v", b.name, err)
+			}
+			return prog.Run(context.Background())
+		}
+
+		code := `CAN HAS STDIO?
+CAN HAS MATH?
+CAN HAS SHAPES?
+CAN HAS MATH?
+CAN HAS GREETER?
+VISIBLE PI " " UNIT " " I IZ SQUARE YR 3 MKAY " " I IZ GREET YR "CAT" MKAY " " GREETING`
+		for _, expected := range []string{"LOADING MATH\n3.14 4 9 O HAI CAT O HAI\n", "3.14 4 9 O HAI CAT O HAI\n"} {
+			out.Reset()
+			if err := run(code); err != nil {
+				t.Fatalf("Run failed with %s: %v", b.name, err)
+			}
+			if out.String() != expected { // each library is loaded once
+				t.Errorf("%s printed %q, expected %q", b.name, out.String(), expected)
+			}
+		}
+
+		for code, msg := range map[string]string{
+			"CAN HAS NOPE?":   "3:1: Library not found: NOPE",
+			"CAN HAS LOOPA?":  filepath.Join(dir, "loopb.lol") + ":2:1: Circular CAN HAS: LOOPA -> LOOPB -> LOOPA",
+			"CAN HAS BROKEN?": "3:1: Failed to compile library BROKEN:\n" + filepath.Join(dir, "broken.lol") + ":2:8: unexpected End-of-line, expected expression",
+		} {
+			err := run("VISIBLE \"\"\n" + code)
+			if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != BadLibrary || err.Error() != msg {
+				t.Errorf("%s\nExpected bad library error %q with %s, got %v", code, msg, b.name, err)
+			}
+		}
+		err := run("CAN HAS CRASH?")
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != DivideByZero || rtErr.Pos.File != filepath.Join(dir, "crash.lol") ||
+			len(rtErr.Stack) != 2 || rtErr.Stack[0].Func != "CRASH" || rtErr.Stack[1].Pos.Line != 2 {
+			t.Errorf("Expected division by zero in crash.lol with %s, got %v", b.name, err)
+		}
+
+		// the program being run counts as loading, so CAN HAS doesn't run it again
+		out.Reset()
+		path := filepath.Join(dir, "main.lol")
+		src, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := in.CompileFile(path, src)
+		src.Close()
+		if err != nil {
+			t.Fatalf("Compile failed with %s: %v", b.name, err)
+		}
+		err = prog.Run(context.Background())
+		msg := filepath.Join(dir, "back.lol") + ":2:1: Circular CAN HAS: main.lol -> BACK -> MAIN"
+		if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != BadLibrary || err.Error() != msg {
+			t.Errorf("Expected bad library error %q with %s, got %v", msg, b.name, err)
+		}
+		if out.String() != "MAIN START\n" {
+			t.Errorf("%s printed %q, expected MAIN START once", b.name, out.String())
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"util/math.lol": "HAI 1.3\nI HAS A PI ITZ 3.14\nHOW IZ I SQUARE YR X\n\tFOUND YR PRODUKT OF X AN X\n" +
+			"IF U SAY SO\nVISIBLE \"LOADING MATH\"\nKTHXBYE\n",
+		"lib/geometry.lol": "HAI 1.3\nCAN HAS \"../util/math.lol\" AZ M?\nI HAS A TAU ITZ PRODUKT OF 2 AN M_PI\n" +
+			"HOW IZ I AREA YR SIDE\n\tFOUND YR PRODUKT OF 3.14 AN I IZ M_SQUARE YR SIDE MKAY\nIF U SAY SO\nKTHXBYE\n",
+		"main.lol": "HAI 1.3\nCAN HAS \"lib/geometry.lol\" AZ GEO?\nCAN HAS \"util/math.lol\"?\n" +
+			"HOW IZ I AREA\n\tFOUND YR \"MINE\"\nIF U SAY SO\n" +
+			"VISIBLE I IZ GEO_AREA YR 2 MKAY \" \" GEO_TAU \" \" I IZ AREA MKAY \" \" I IZ SQUARE YR 3 MKAY \" \" PI\nKTHXBYE\n",
+		"lonely.lol":    "HAI 1.3\nHOW IZ I AREA\n\tFOUND YR 0\nIF U SAY SO\nCAN HAS \"lib/needy.lol\"?\nKTHXBYE\n",
+		"lib/needy.lol": "HAI 1.3\nI IZ AREA MKAY\nKTHXBYE\n",
+		"loopa.lol":     "HAI 1.3\nCAN HAS \"loopb.lol\"?\nKTHXBYE\n",
+		"loopb.lol":     "HAI 1.3\nCAN HAS \"loopa.lol\"?\nKTHXBYE\n",
+		"missing.lol":   "HAI 1.3\nCAN HAS \"nope.lol\"?\nKTHXBYE\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, b := range backends {
+		var out bytes.Buffer
+		run := func(name string) error {
+			in := New(b.opt, WithStdout(&out))
+			path := filepath.Join(dir, name)
+			src, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			prog, err := in.CompileFile(path, src)
+			if err != nil {
+				t.Fatalf("Compile failed with %s: %v", b.name, err)
+			}
+			return prog.Run(context.Background())
+		}
+
+		// each file is loaded once, whatever path reaches it, and AZ keeps the AREAs apart
+		if err := run("main.lol"); err != nil {
+			t.Fatalf("Run failed with %s: %v", b.name, err)
+		}
+		if expected := "LOADING MATH\n12.56 6.28 MINE 9 3.14\n"; out.String() != expected {
+			t.Errorf("%s printed %q, expected %q", b.name, out.String(), expected)
+		}
+
+		for name, msg := range map[string]string{
+			"missing.lol": filepath.Join(dir, "missing.lol") + ":2:1: File not found: " + filepath.Join(dir, "nope.lol"),
+			"loopa.lol":   filepath.Join(dir, "loopb.lol") + ":2:1: Circular CAN HAS: loopa.lol -> loopb.lol -> loopa.lol",
+		} {
+			err := run(name)
+			if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != BadLibrary || err.Error() != msg {
+				t.Errorf("%s: expected bad library error %q with %s, got %v", name, msg, b.name, err)
+			}
+		}
+		// a file only calls the functions it defines or loads itself
+		err := run("lonely.lol")
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != UndefinedFunc || rtErr.Pos.File != filepath.Join(dir, "lib", "needy.lol") ||
+			len(rtErr.Stack) != 2 || rtErr.Stack[0].Func != "lib/needy.lol" {
+			t.Errorf("Expected undefined function in needy.lol with %s, got %v", b.name, err)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	code := `HAI 1.2
+I HAS A TOTAL ITZ 0
+IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 3
+	I HAS A SQUARE ITZ PRODUKT OF N AN N
+	TOTAL R SUM OF TOTAL AN SQUARE
+	SQUARE
+IM OUTTA YR LOOP
+VISIBLE TOTAL " " IT
+KTHXBYE
+`
+	out, err := runProgram(t, code, "")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "5 4\n" {
+		t.Fatalf("Program printed %q", out)
+	}
+
+	failures := []string{
+		// loop variables and loop locals are gone once the loop is done
+		"IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 3\nIM OUTTA YR LOOP\nVISIBLE N",
+		"IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 3\nI HAS A M\nIM OUTTA YR LOOP\nM",
+		// functions can't assign the globals
+		"I HAS A X\nHOW IZ I F\nX R 1\nIF U SAY SO\nI IZ F MKAY",
+	}
+	for _, code := range failures {
+		if _, err := runProgram(t, "HAI 1.2\n"+code+"\nKTHXBYE\n", ""); err == nil {
+			t.Fatalf("%s\nExpected runtime error", code)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	code := `HAI 1.2
+HOW IZ I INNER YR X
+	VISIBLE "INNER"
+	  FOUND YR QUOSHUNT OF X AN 0
+IF U SAY SO
+HOW IZ I OUTER
+	FOUND YR I IZ INNER YR 1 MKAY
+IF U SAY SO
+I IZ OUTER MKAY
+KTHXBYE
+`
+	_, err := runProgram(t, code, "")
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected *RuntimeError, got %v", err)
+	}
+	if rtErr.Kind != DivideByZero || rtErr.Pos != (token.Pos{Line: 4, Col: 4}) {
+		t.Fatalf("Unexpected error %v of kind %v", rtErr, rtErr.Kind)
+	}
+	expected := []StackFrame{
+		{"INNER", token.Pos{Line: 4, Col: 4}},
+		{"OUTER", token.Pos{Line: 7, Col: 2}},
+		{"", token.Pos{Line: 9, Col: 1}},
+	}
+	if len(rtErr.Stack) != len(expected) {
+		t.Fatalf("Unexpected stack:\n%s", rtErr.Trace())
+	}
+	for i := range expected {
+		if rtErr.Stack[i] != expected[i] {
+			t.Fatalf("Unexpected stack:\n%s", rtErr.Trace())
+		}
+	}
+
+	kinds := map[string]ErrorKind{
+		"X":                 UndefinedVar,
+		"X R 1":             UndefinedVar,
+		"I IZ F MKAY":       UndefinedFunc,
+		"MOD OF 1.5 AN 1":   BadType,
+		`SUM OF "CAT" AN 1`: BadCast,
+		"MOD OF 1 AN 0":     DivideByZero,
+		"GTFO":              BadJump,
+		"HOW IZ I F\nIF U SAY SO\nI IZ F YR 1 MKAY": BadArgCount,
+	}
+	for code, kind := range kinds {
+		_, err := runProgram(t, "HAI 1.2\n"+code+"\nKTHXBYE\n", "")
+		if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != kind {
+			t.Fatalf("%s\nExpected %v error, got %v", code, kind, err)
+		}
+	}
+
+	// deep recursion leaves a short trace
+	for code, lines := range map[string]int{
+		"HOW IZ I F YR N\n\tI IZ F YR N MKAY\nIF U SAY SO\nI IZ F YR 0 MKAY":                          3,
+		"HOW IZ I F\n\tI IZ G MKAY\nIF U SAY SO\nHOW IZ I G\n\tI IZ F MKAY\nIF U SAY SO\nI IZ F MKAY": maxTraceLines + 1,
+	} {
+		_, err := runProgram(t, "HAI 1.2\n"+code+"\nKTHXBYE\n", "")
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != TooDeep || strings.Count(rtErr.Trace(), "\n") != lines {
+			t.Fatalf("%s\nExpected TooDeep error with a trace of %d lines, got %v", code, lines, err)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	type testCase struct {
+		code     string
+		expected string
+	}
+	testCases := []testCase{
+		{"I HAS A FISH ITZ\n", "2:17: unexpected End-of-line, expected expression, A or LIEK A"},
+		{"FISH BAR\n", "2:6: unexpected identifier BAR, expected 'Z, End-of-line, R, IS NOW A, HAS A or IZ"},
+		{"FISH IS NOW A BAR\n", "2:15: unexpected identifier BAR, expected type"},
+		{"FISH R MAEK FISH A 1\n", "2:20: unexpected literal 1, expected type"},
+		{"FISH R MAEK FISH\n", "2:17: unexpected End-of-line, expected type"},
+		{"VISIBLE YARN\n", "2:9: YARN is a type, not a value"},
+		{"VISIBLE 1 MKAY 2\n", "2:16: unexpected literal 2, expected ! or End-of-line"},
+		{"SUM OF 1 AN 2 AN 3\n", "2:15: unexpected AN, expected End-of-line"},
+		{"VISIBLE 1\nYA RLY\n", "3:1: unexpected YA RLY, expected statement or KTHXBYE"},
+		{"VISIBLE \"HAI\n", "2:9: Unterminated YARN literal: \"HAI"},
+		{"WTF?\nOMG 1\nGTFO\nOMG 1.0\nGTFO\nOIC\n", "5:1: Duplicate OMG label in WTF?: 1"},
+		{"IM IN YR LOOP\nGTFO\nIM OUTTA YR LOOOP\n", "2:1: IM OUTTA YR LOOOP does not match IM IN YR LOOP"},
+		{"HOW IZ I F YR X AN YR X\nIF U SAY SO\n", "2:1: Duplicate parameter X in HOW IZ I F"},
+	}
+	for _, tc := range testCases {
+		for _, d := range []*parser.Dialect{D, bytecodeDialects[LatestVersion]} {
+			_, _, err := d.Parse(Source, tokenChan("HAI 1.2\n"+tc.code+"KTHXBYE\n"))
+			var synErr *parser.SyntaxError
+			if !errors.As(err, &synErr) || err.Error() != tc.expected {
+				t.Fatalf("%s\nExpected error %q, got %v", tc.code, tc.expected, err)
+			}
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	features := []struct {
+		code, msg string
+	}{
+		{"I HAS A B ITZ A BUKKIT\n", "2:15: ITZ A needs HAI 1.3, not HAI 1.2"},
+		{"I HAS A B ITZ LIEK A C\n", "2:15: LIEK A needs HAI 1.3, not HAI 1.2"},
+		{"B IS NOW A BUKKIT\n", "2:3: BUKKIT needs HAI 1.3, not HAI 1.2"},
+		{"VISIBLE MAEK B A BUKKIT\n", "2:16: BUKKIT needs HAI 1.3, not HAI 1.2"},
+		{"B HAS A X\n", "2:3: HAS A needs HAI 1.3, not HAI 1.2"},
+		{"VISIBLE B'Z X\n", "2:10: 'Z needs HAI 1.3, not HAI 1.2"},
+		{"B IZ F MKAY\n", "2:3: IZ needs HAI 1.3, not HAI 1.2"},
+		{"HOW IZ B F\nIF U SAY SO\n", "2:1: HOW IZ needs HAI 1.3, not HAI 1.2"},
+		{"SRS \"X\" R 1\n", "2:1: SRS needs HAI 1.3, not HAI 1.2"},
+		{"I HAS A SRS \"X\"\n", "2:9: SRS needs HAI 1.3, not HAI 1.2"},
+		{"CAN HAS \"X.lol\"?\n", "2:9: CAN HAS a file needs HAI 1.3, not HAI 1.2"},
+		{"CAN HAS X AZ Y?\n", "2:11: AZ needs HAI 1.3, not HAI 1.2"},
+	}
+	for _, b := range backends {
+		in := New(b.opt)
+		for _, tc := range features {
+			for _, hai := range []string{"HAI 1.2\n", "HAI\n"} { // 1.2 is the default
+				_, err := in.Compile(strings.NewReader(hai + tc.code + "KTHXBYE\n"))
+				if err == nil || err.Error() != tc.msg {
+					t.Errorf("%s%s\nExpected error %q with %s, got %v", hai, tc.code, tc.msg, b.name, err)
+				}
+			}
+			if _, err := in.Compile(strings.NewReader("HAI 1.3\n" + tc.code + "KTHXBYE\n")); err != nil {
+				t.Errorf("HAI 1.3\n%s\nCompile failed with %s: %v", tc.code, b.name, err)
+			}
+		}
+		for _, hai := range []string{"HAI 1.4", "HAI 2", "HAI 1.2.1"} {
+			_, err := in.Compile(strings.NewReader(hai + "\nKTHXBYE\n"))
+			expected := "1:5: Unsupported version " + hai + ", expected 1.2 or 1.3"
+			if hai == "HAI 1.2.1" {
+				expected = "1:5: Syntax error: unexpected token 1.2.1"
+			}
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected error %q with %s, got %v", expected, b.name, err)
+			}
+		}
+	}
+
+	// AZ is only a keyword after CAN HAS
+	for _, hai := range []string{"HAI 1.2\n", "HAI 1.3\n"} {
+		out, err := runProgram(t, hai+"I HAS A AZ ITZ 1\nHOW IZ I AZ\nFOUND YR 2\nIF U SAY SO\nVISIBLE AZ I IZ AZ MKAY\nKTHXBYE\n", "")
+		if err != nil || out != "12\n" {
+			t.Errorf("%sExpected AZ as a name to print 12, got %q and %v", hai, out, err)
+		}
+	}
+	if _, err := New().Compile(strings.NewReader("HAI 1.3\nCAN HAS X AS Y?\nKTHXBYE\n")); err == nil ||
+		err.Error() != "2:11: expected AZ or ?, not AS" {
+		t.Errorf("Expected error for a misspelled AZ, got %v", err)
+	}
+
+	// the words of 1.3 are reserved in 1.2 too
+	for code, msg := range map[string]string{
+		"I HAS A IZ\n":  "2:9: unexpected IZ, expected identifier",
+		"I HAS A SRS\n": "2:12: unexpected End-of-line, expected expression",
+	} {
+		if _, err := New().Compile(strings.NewReader("HAI 1.2\n" + code + "KTHXBYE\n")); err == nil || err.Error() != msg {
+			t.Errorf("%s\nExpected error %q, got %v", code, msg, err)
+		}
+	}
+	for _, tc := range []struct {
+		a, b string
+		less bool
+	}{
+		{"1.2", "1.3", true}, {"1.3", "1.10", true}, {"1.10", "1.3", false}, {"1.3", "1.3", false}, {"2", "1.3", false},
+	} {
+		if versionLess(tc.a, tc.b) != tc.less {
+			t.Errorf("Expected versionLess(%s, %s) to be %v", tc.a, tc.b, tc.less)
+		}
+	}
+
+	// the dialects can be used directly too
+	if _, _, err := Dialects["1.2"].Parse(Statement, tokenChan("B'Z X R 1\n")); err == nil ||
+		err.Error() != "1:2: 'Z needs HAI 1.3, not HAI 1.2" {
+		t.Errorf("Expected 1.2 to reject slots, got %v", err)
+	}
+	if _, _, err := Dialects["1.3"].Parse(Statement, tokenChan("B'Z X R 1\n")); err != nil {
+		t.Errorf("Expected 1.3 to accept slots, got %v", err)
+	}
+}
+
+func TestMultipleSyntaxErrors(t *testing.T) {
+	code := `HAI 1.2
+I HAS A FISH ITZ
+VISIBLE "FISH"
+BOTH SAEM FISH AN 1, O RLY?
+	YA RLY
+		VISIBLE "HAI
+		FISH R
+	NO WAI
+		IM IN YR LOOP
+			VISIBLE FISH
+		IM OUTTA YR LOOOP
+OIC
+VISIBLE SUM OF FISH
+FISH IS NOW A TROOF
+KTHXBYE
+`
+	_, _, err := D.Parse(Source, tokenChan(code))
+	var errs parser.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+	expected := []string{
+		"2:17: unexpected End-of-line, expected expression, A or LIEK A",
+		"6:11: Unterminated YARN literal: \"HAI",
+		"7:9: unexpected End-of-line, expected expression",
+		"9:3: IM OUTTA YR LOOOP does not match IM IN YR LOOP",
+		"13:20: unexpected End-of-line, expected AN",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), err)
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Fatalf("Expected error %q, got %q", expected[i], e)
+		}
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	forBackends(t, testInterpreter)
+}
+
+func testInterpreter(t *testing.T, opt Option) {
+	type score int
+	in := New(opt, WithStdout(io.Discard))
+	for name, val := range map[string]interface{}{
+		"NAME": "CEILING CAT", "SCORE": score(41), "RATIO": float32(0.5), "HUNGRY": true,
+	} {
+		if err := in.Set(name, val); err != nil {
+			t.Fatalf("Set %s: %v", name, err)
+		}
+	}
+	if err := in.Set("CHAN", make(chan int)); err == nil {
+		t.Fatalf("Expected an error setting a channel")
+	}
+	prog, err := in.Compile(strings.NewReader(`HAI 1.2
+SCORE R SUM OF SCORE AN 1
+RATIO R PRODUKT OF RATIO AN 3
+I HAS A GREETING ITZ SMOOSH "O HAI " AN NAME MKAY
+HUNGRY R NOT HUNGRY
+KTHXBYE
+`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	for i := 0; i < 2; i++ { // globals persist between runs
+		if err := prog.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	for name, expected := range map[string]interface{}{
+		"SCORE": int64(43), "RATIO": 4.5, "GREETING": "O HAI CEILING CAT", "HUNGRY": true, "IT": nil,
+	} {
+		if val, ok := in.Get(name); !ok || val != expected {
+			t.Errorf("%s is %v %T, expected %v %T", name, val, val, expected, expected)
+		}
+	}
+	if _, ok := in.Get("NOTHING"); ok {
+		t.Errorf("Got a variable that was never declared")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := prog.Run(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	_, err = in.Compile(strings.NewReader("HAI 1.2\nKTHXBYE\nVISIBLE 1\n"))
+	if err == nil || err.Error() != "3:1: unexpected VISIBLE, expected End-of-file" {
+		t.Errorf("Expected error after KTHXBYE, got %v", err)
+	}
+}
+
+func TestGoFuncs(t *testing.T) {
+	forBackends(t, testGoFuncs)
+}
+
+func testGoFuncs(t *testing.T, opt Option) {
+	errNoCheez := errors.New("no cheez")
+	var out bytes.Buffer
+	in := New(opt, WithStdout(&out))
+	in.Register("ADD", 2, func(args ...Value) (Value, error) {
+		return int(args[0].(int64) + args[1].(int64)), nil // converted to a NUMBR
+	})
+	in.Register("SHOUT", 1, func(args ...Value) (Value, error) {
+		return strings.ToUpper(args[0].(string)) + "!", nil
+	})
+	in.Register("CHEEZ", 0, func(args ...Value) (Value, error) {
+		return nil, errNoCheez
+	})
+	in.Register("CHAN", 0, func(args ...Value) (Value, error) {
+		return make(chan int), nil
+	})
+	in.Register("DOUBLE", 1, func(args ...Value) (Value, error) {
+		return args[0].(int64) * 2, nil // panics given a YARN
+	})
+	run := func(code string) error {
+		prog, err := in.Compile(strings.NewReader("HAI 1.2\n" + code + "KTHXBYE\n"))
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		return prog.Run(context.Background())
+	}
+
+	code := `HOW IZ I TWICE YR X
+	FOUND YR I IZ ADD YR X AN YR X MKAY
+IF U SAY SO
+VISIBLE I IZ TWICE YR 21 MKAY
+VISIBLE I IZ SHOUT YR "o hai" MKAY
+`
+	if err := run(code); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out.String() != "42\nO HAI!\n" {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+
+	err := run("I IZ ADD YR 1 MKAY\n")
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Kind != BadArgCount {
+		t.Errorf("Expected %v, got %v", BadArgCount, err)
+	}
+	err = run("VISIBLE \"CAN HAS\"\nI IZ CHEEZ MKAY\n")
+	if !errors.As(err, &rtErr) || rtErr.Kind != GoFail || !errors.Is(err, errNoCheez) {
+		t.Errorf("Expected %v wrapping %v, got %v", GoFail, errNoCheez, err)
+	}
+	if err.Error() != "3:1: CHEEZ: no cheez" {
+		t.Errorf("Unexpected error message %q", err)
+	}
+	err = run("I IZ CHAN MKAY\n")
+	if !errors.As(err, &rtErr) || rtErr.Kind != GoFail {
+		t.Errorf("Expected %v for a bad return type, got %v", GoFail, err)
+	}
+	err = run("I IZ DOUBLE YR \"X\" MKAY\n")
+	if !errors.As(err, &rtErr) || rtErr.Kind != GoFail || rtErr.Pos.Line != 2 ||
+		!strings.HasPrefix(err.Error(), "2:1: DOUBLE: interface conversion") {
+		t.Errorf("Expected %v for a panic, got %v", GoFail, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	forBackends(t, testLimits)
+}
+
+func testLimits(t *testing.T, opt Option) {
+	run := func(ctx context.Context, code string, opts ...Option) error {
+		in := New(append(opts, opt, WithStdout(io.Discard))...)
+		prog, err := in.Compile(strings.NewReader("HAI 1.3\n" + code + "KTHXBYE\n"))
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		return prog.Run(ctx)
+	}
+	forever := "IM IN YR LOOP\nIM OUTTA YR LOOP\n"
+	recurse := "HOW IZ I F YR N\n\tI IZ F YR SUM OF N AN 1 MKAY\nIF U SAY SO\nI IZ F YR 0 MKAY\n"
+	hog := "I HAS A S ITZ \"LOL\"\nIM IN YR LOOP\n\tS R SMOOSH S AN S MKAY\nIM OUTTA YR LOOP\n"
+	slotHog := "I HAS A B ITZ A BUKKIT\nIM IN YR LOOP UPPIN YR N\n\tB HAS A SRS N\nIM OUTTA YR LOOP\n"
+	bukkitHog := "IM IN YR LOOP\n\tI HAS A B ITZ A BUKKIT\nIM OUTTA YR LOOP\n"
+	liekHog := "I HAS A B ITZ A BUKKIT\nIM IN YR LOOP\n\tI HAS A C ITZ LIEK A B\nIM OUTTA YR LOOP\n"
+	castHog := "IM IN YR LOOP\n\tMAEK NOOB A BUKKIT\nIM OUTTA YR LOOP\n"
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	for _, tc := range []struct {
+		ctx      context.Context
+		code     string
+		opts     []Option
+		expected ErrorKind
+		msg      string
+	}{
+		{timeout, forever, nil, Canceled, "2:1: Stopped: context deadline exceeded"},
+		{context.Background(), forever, []Option{WithMaxSteps(100)}, TooManySteps, "2:1: Ran more than 100 steps"},
+		{context.Background(), recurse, nil, TooDeep, "3:2: Calls nested more than 10000 deep"},
+		{context.Background(), recurse, []Option{WithMaxDepth(5)}, TooDeep, "3:2: Calls nested more than 5 deep"},
+		{context.Background(), hog, []Option{WithMaxAlloc(1000)}, TooMuchAlloc, "4:2: Allocated more than 1000 bytes"},
+		{context.Background(), slotHog, []Option{WithMaxAlloc(1000)}, TooMuchAlloc, "4:2: Allocated more than 1000 bytes"},
+		{context.Background(), bukkitHog, []Option{WithMaxAlloc(1000)}, TooMuchAlloc, "3:2: Allocated more than 1000 bytes"},
+		{context.Background(), liekHog, []Option{WithMaxAlloc(1000)}, TooMuchAlloc, "4:2: Allocated more than 1000 bytes"},
+		{context.Background(), castHog, []Option{WithMaxAlloc(1000)}, TooMuchAlloc, "3:2: Allocated more than 1000 bytes"},
+	} {
+		err := run(tc.ctx, tc.code, tc.opts...)
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) || rtErr.Kind != tc.expected || err.Error() != tc.msg {
+			t.Errorf("%s\nExpected %v error %q, got %v", tc.code, tc.expected, tc.msg, err)
+		}
+	}
+	if err := run(timeout, forever); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected error to wrap the context's, got %v", err)
+	}
+	if err := run(context.Background(), "VISIBLE SMOOSH \"CHEEZ\" AN \"BURGER\" MKAY\n",
+		WithMaxSteps(2), WithMaxAlloc(11), WithMaxDepth(1)); err != nil {
+		t.Errorf("Expected program within its limits to run, got %v", err)
+	}
+
+	// a run waiting in GIMMEH is canceled, and the line it waited for goes to the next
+	stdin, feed := io.Pipe()
+	var out bytes.Buffer
+	in := New(opt, WithStdin(stdin), WithStdout(&out))
+	prog, err := in.Compile(strings.NewReader("HAI 1.2\nI HAS A X\nGIMMEH X\nVISIBLE X\nKTHXBYE\n"))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	waiting, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var rtErr *RuntimeError
+	if err := prog.Run(waiting); !errors.As(err, &rtErr) || rtErr.Kind != Canceled ||
+		err.Error() != "3:1: Stopped: context deadline exceeded" {
+		t.Errorf("Expected GIMMEH to be canceled, got %v", err)
+	}
+	go feed.Write([]byte("CHEEZ\n"))
+	if err := prog.Run(context.Background()); err != nil || out.String() != "CHEEZ\n" {
+		t.Errorf("Expected the next run to print CHEEZ, got %q and %v", out.String(), err)
+	}
+}
+
+// TestCasts checks the casts of the spec: explicit ones with MAEK, and implicit ones
+// where a math operator, SMOOSH or a condition needs a value of another type
+func TestCasts(t *testing.T) {
+	forBackends(t, testCasts)
+}
+
+func testCasts(t *testing.T, opt Option) {
+	const noErr = ErrorKind(-1) // the cast succeeds with the expected value
+	// a NUMBAR whose square is infinite
+	huge := `"1` + strings.Repeat("0", 200) + `.0"`
+	for _, tc := range []struct {
+		code     string
+		expected interface{}
+		err      ErrorKind
+	}{
+		// explicit
+		{"MAEK NOOB A TROOF", false, noErr},
+		{"MAEK NOOB A NUMBR", int64(0), noErr},
+		{"MAEK NOOB A NUMBAR", 0.0, noErr},
+		{"MAEK NOOB A YARN", "", noErr},
+		{"MAEK WIN A NUMBR", int64(1), noErr},
+		{"MAEK FAIL A NUMBAR", 0.0, noErr},
+		{"MAEK WIN A YARN", "WIN", noErr},
+		{"MAEK FAIL A YARN", "FAIL", noErr},
+		{"MAEK 0 A TROOF", false, noErr},
+		{"MAEK -7 A TROOF", true, noErr},
+		{"MAEK 0.0 A TROOF", false, noErr},
+		{"MAEK 0.1 A TROOF", true, noErr},
+		{"MAEK -7 A NUMBAR", -7.0, noErr},
+		{"MAEK -7 A YARN", "-7", noErr},
+		{"MAEK 3.99 A NUMBR", int64(3), noErr},
+		{"MAEK -3.99 A NUMBR", int64(-3), noErr},
+		{`MAEK "-9223372036854775808.0" A NUMBR`, int64(math.MinInt64), noErr},
+		{`MAEK "9223372036854775808.0" A NUMBR`, nil, BadCast},
+		{`MAEK "-100000000000000000000.0" A NUMBR`, nil, BadCast},
+		{"MAEK PRODUKT OF " + huge + " AN " + huge + " A NUMBR", nil, BadCast},
+		{"MAEK DIFF OF PRODUKT OF " + huge + " AN " + huge + " AN PRODUKT OF " + huge + " AN " + huge + " A NUMBR", nil, BadCast},
+		{"MAEK 3.14159 A YARN", "3.14", noErr},
+		{"MAEK 2.0 A YARN", "2.00", noErr},
+		{"MAEK 0.5 A YARN", "0.50", noErr},
+		{"MAEK 0.29 A YARN", "0.29", noErr},
+		{"MAEK -1.999 A YARN", "-1.99", noErr},
+		{"MAEK -0.001 A YARN", "0.00", noErr},
+		{`MAEK "" A TROOF`, false, noErr},
+		{`MAEK "0" A TROOF`, true, noErr},
+		{`MAEK "FAIL" A TROOF`, true, noErr},
+		{`MAEK "42" A NUMBR`, int64(42), noErr},
+		{`MAEK "-42" A NUMBAR`, -42.0, noErr},
+		{`MAEK "3.7" A NUMBR`, int64(3), noErr},
+		{`MAEK "-.5" A NUMBAR`, -0.5, noErr},
+		{`MAEK "" A NUMBR`, nil, BadCast},
+		{`MAEK "CAT" A NUMBR`, nil, BadCast},
+		{`MAEK "4 2" A NUMBR`, nil, BadCast},
+		{`MAEK "0x1F" A NUMBR`, nil, BadCast},
+		{`MAEK "1e3" A NUMBAR`, nil, BadCast},
+		{`MAEK "1.2.3" A NUMBAR`, nil, BadCast},
+		{`MAEK "-" A NUMBAR`, nil, BadCast},
+		{`MAEK "99999999999999999999" A NUMBR`, nil, BadCast},
+		{`MAEK "O HAI" A YARN`, "O HAI", noErr},
+		{"MAEK 1 A NOOB", nil, noErr},
+
+		// implicit
+		{"SUM OF WIN AN 1", int64(2), noErr},
+		{"SUM OF FAIL AN 1.5", 1.5, noErr},
+		{`SUM OF "2" AN 1`, int64(3), noErr},
+		{`SUM OF "2.0" AN 1`, 3.0, noErr},
+		{`QUOSHUNT OF 7 AN "2"`, int64(3), noErr},
+		{`PRODUKT OF "CAT" AN 1`, nil, BadCast},
+		{`SUM OF "" AN 1`, nil, BadCast},
+		{"SUM OF NOOB AN 1", nil, BadCast},
+		{"SMOOSH 1 AN 2.5 AN WIN AN FAIL MKAY", "12.50WINFAIL", noErr},
+		{"SMOOSH NOOB MKAY", nil, BadCast},
+		{"NOT NOOB", true, noErr},
+		{`NOT ""`, true, noErr},
+		{`NOT "0"`, false, noErr},
+		{"NOT 0.0", true, noErr},
+		{"BOTH OF 1 AN 2.5", true, noErr},
+		{`BOTH SAEM 1 AN 1.0`, true, noErr},
+		{`BOTH SAEM "1" AN 1`, false, noErr},
+		{`BOTH SAEM NOOB AN FAIL`, false, noErr},
+	} {
+		in := New(opt, WithStdout(io.Discard))
+		prog, err := in.Compile(strings.NewReader("HAI 1.2\n" + tc.code + "\nKTHXBYE\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.code, err)
+		}
+		err = prog.Run(context.Background())
+		if tc.err != noErr {
+			var rtErr *RuntimeError
+			if !errors.As(err, &rtErr) || rtErr.Kind != tc.err {
+				t.Errorf("%s: expected %v error, got %v", tc.code, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.code, err)
+			continue
+		}
+		if it, _ := in.Get("IT"); it != tc.expected {
+			t.Errorf("%s is %#v, expected %#v", tc.code, it, tc.expected)
+		}
+	}
+
+	// IS NOW A casts in place, with the same rules as MAEK
+	out, err := runProgram(t, `HAI 1.2
+I HAS A X ITZ "12.345"
+X IS NOW A NUMBAR
+VISIBLE X
+X IS NOW A NUMBR
+VISIBLE X
+X IS NOW A TROOF
+VISIBLE X
+KTHXBYE
+`, "")
+	if err != nil || out != "12.34\n12\nWIN\n" {
+		t.Errorf("IS NOW A gave %q, %v", out, err)
+	}
+}
+
+// BenchmarkBackends compares the backends on programs that are mostly math, function
+// calls and string building
+func BenchmarkBackends(b *testing.B) {
+	for _, bc := range []struct {
+		name, code string
+	}{
+		{"loop", `I HAS A TOTAL ITZ 0
+IM IN YR LOOP UPPIN YR N TIL BOTH SAEM N AN 100000
+	TOTAL R SUM OF TOTAL AN MOD OF PRODUKT OF N AN N AN 7
+IM OUTTA YR LOOP
+`},
+		{"fib", `HOW IZ I FIB YR N
+	BOTH SAEM BIGGR OF N AN 1 AN 1, O RLY?
+		YA RLY, FOUND YR N
+	OIC
+	FOUND YR SUM OF I IZ FIB YR DIFF OF N AN 1 MKAY AN I IZ FIB YR DIFF OF N AN 2 MKAY
+IF U SAY SO
+I IZ FIB YR 20 MKAY
+`},
+		{"smoosh", `I HAS A S ITZ ""
+IM IN YR LOOP UPPIN YR N WILE DIFFRINT N AN 1000
+	S R SMOOSH "LOL" AN N MKAY
+IM OUTTA YR LOOP
+`},
+	} {
+		for _, backend := range backends {
+			b.Run(bc.name+"/"+backend.name, func(b *testing.B) {
+				in := New(backend.opt, WithStdout(io.Discard))
+				prog, err := in.Compile(strings.NewReader("HAI 1.2\n" + bc.code + "KTHXBYE\n"))
+				if err != nil {
+					b.Fatalf("Compile failed: %v", err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := prog.Run(context.Background()); err != nil {
+						b.Fatalf("Run failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package lang
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Library is a module of functions and variables from Go, which programs load
+// with CAN HAS.  Libraries may also be written in Lolcode: see WithLibraryPath.
+type Library struct {
+	funcs map[string]*function
+	vars  map[string]interface{}
+}
+
+// NewLibrary makes an empty Library
+func NewLibrary() *Library {
+	return &Library{funcs: make(map[string]*function), vars: make(map[string]interface{})}
+}
+
+// Register adds a function to the library, as Interpreter.Register would
+// make it callable once the library is loaded
+func (l *Library) Register(name string, nargs int, fn GoFunc) {
+	l.funcs[name] = &function{name: name, nargs: nargs, goFunc: fn}
+}
+
+// Set adds a variable to the library.  val may be of any type Interpreter.Set accepts.
+func (l *Library) Set(name string, val interface{}) error {
+	v, err := fromGo(val)
+	if err != nil {
+		return err
+	}
+	l.vars[name] = v
+	return nil
+}
+
+// AddLibrary makes lib the library that CAN HAS name? loads, in place of any file
+// of that name on the library path.  The STDIO library, which is empty, is there
+// from the start, since VISIBLE and GIMMEH need nothing loaded.
+func (in *Interpreter) AddLibrary(name string, lib *Library) {
+	in.libs[name] = lib
+}
+
+// WithLibraryPath makes CAN HAS look for a library that wasn't added from Go in
+// the files NAME.lol, then name.lol, of each directory in turn.  A library file
+// is a program of its own; the variables it leaves in its global scope, and the
+// functions it defines or loads, are what it gives to the programs that load it.
+// In HAI 1.3, CAN HAS also loads a file given by a path in a YARN, relative to the
+// file loading it, and CAN HAS ... AZ PREFIX? puts PREFIX_ before the names it gives.
+func WithLibraryPath(dirs ...string) Option {
+	return func(in *Interpreter) {
+		in.libPath = dirs
+	}
+}
+
+// A libImport is what a CAN HAS statement loads, and the prefix AZ gives its names
+type libImport struct {
+	name   string // of a library, or the path of a file relative to the one loading it
+	file   bool
+	prefix string
+}
+
+// String writes the import as a CAN HAS statement
+func (l libImport) String() string {
+	s := "CAN HAS " + l.name
+	if l.file {
+		s = "CAN HAS " + strconv.Quote(l.name)
+	}
+	if l.prefix != "" {
+		s += " AZ " + l.prefix
+	}
+	return s + "?"
+}
+
+// A module is what loading a library or file gives the code that loads it
+type module struct {
+	vars  map[string]interface{}
+	funcs map[string]*function
+}
+
+// A loading is a library or file being loaded, under the key of its module
+type loading struct {
+	key, name string
+}
+
+// canHas loads a library or file, unless the interpreter has loaded it already, and
+// gives its functions and variables to the top frame, with the prefix if there is one
+func (r *run) canHas(lib libImport, declare func(name string, val interface{})) {
+	key, path := lib.name, ""
+	switch {
+	case lib.file:
+		path = lib.name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(r.top.pos.File), path)
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			raise(BadLibrary, "File not found: %s", path)
+		}
+	case r.libs[lib.name] == nil:
+		path = r.findLibrary(lib.name)
+		if path == "" {
+			raise(BadLibrary, "Library not found: %s", lib.name)
+		}
+	}
+	if path != "" {
+		key = moduleKey(path)
+	}
+	m, ok := r.loaded[key]
+	if !ok {
+		m = r.load(lib.name, key, path)
+		r.loaded[key] = m
+	}
+
+	prefix := ""
+	if lib.prefix != "" {
+		prefix = lib.prefix + "_"
+	}
+	for n, v := range m.vars {
+		declare(prefix+n, v)
+	}
+	for n, f := range m.funcs {
+		r.top.funcs[prefix+n] = f
+	}
+}
+
+// moduleKey is the key of the module loaded from the file at path: its absolute
+// path, since the same file is the same module, however it's named
+func moduleKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// load runs the library name, or the file at path if it's a Lolcode library
+func (r *run) load(name, key, path string) *module {
+	for i, l := range r.loading {
+		if l.key == key {
+			var names []string
+			for _, l := range r.loading[i:] {
+				names = append(names, l.name)
+			}
+			raise(BadLibrary, "Circular CAN HAS: %s -> %s", strings.Join(names, " -> "), name)
+		}
+	}
+	if path == "" {
+		lib := r.libs[name]
+		return &module{vars: lib.vars, funcs: lib.funcs}
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		raise(BadLibrary, "Failed to read library %s: %v", name, err)
+	}
+	prog, err := r.CompileFile(path, src)
+	src.Close()
+	if err != nil {
+		raise(BadLibrary, "Failed to compile library %s:\n%v", name, err)
+	}
+
+	// A library calls the functions it defines or loads, and those registered from Go
+	m := &module{funcs: make(map[string]*function)}
+	for n, f := range r.Interpreter.funcs {
+		if f.goFunc != nil {
+			m.funcs[n] = f
+		}
+	}
+	r.loading = append(r.loading, loading{key, name})
+	caller := r.top
+	m.vars = r.runLibrary(name, prog, m.funcs)
+	r.top, r.loading = caller, r.loading[:len(r.loading)-1]
+	delete(m.vars, "IT")
+	for n, f := range m.funcs {
+		if r.Interpreter.funcs[n] == f {
+			delete(m.funcs, n)
+		}
+	}
+	return m
+}
+
+func (in *Interpreter) findLibrary(name string) string {
+	for _, dir := range in.libPath {
+		for _, file := range []string{name + ".lol", strings.ToLower(name) + ".lol"} {
+			path := filepath.Join(dir, file)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// runLibrary runs the program of a library in a frame of its own, which calls funcs,
+// and returns its globals
+func (r *run) runLibrary(name string, p *Program, funcs map[string]*function) map[string]interface{} {
+	if p.code != nil {
+		r.pushFrame(name).funcs = funcs
+		return r.runCode(p.code, map[string]interface{}{"IT": nil})
+	}
+	ns := r.newFrame(name)
+	ns.frame.funcs = funcs
+	r.runTop(p.body, ns)
+	return ns.vars
+}
+
+// canHas takes the libImport of the library or file and an optional AZ prefix
+func canHas(args []interface{}) interface{} {
+	lib := args[1].(libImport)
+	lib.prefix, _ = args[2].(string)
+	return statement(func(ns *namespace) flow {
+		ns.frame.run.canHas(lib, ns.declare)
+		return flowNext
+	})
+}
+
+func libName(args []interface{}) interface{} {
+	return libImport{name: args[0].(string)}
+}
+
+// libFile is the path of a file in a YARN literal
+func libFile(args []interface{}) interface{} {
+	if path, ok := args[0].(string); ok {
+		return libImport{name: path, file: true}
+	}
+	return fmt.Errorf("CAN HAS needs the name of a library, or the path of a file in a YARN")
+}
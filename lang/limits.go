@@ -0,0 +1,74 @@
+package lang
+
+// DefaultMaxDepth is the call depth limit of an Interpreter unless WithMaxDepth changes it.
+// Going much deeper could overflow the Go stack, which can't be recovered from.
+const DefaultMaxDepth = 10000
+
+// WithMaxSteps stops a run with TooManySteps after it has run n statements or
+// loop iterations.  0, the default, means no limit.
+func WithMaxSteps(n int) Option {
+	return func(in *Interpreter) {
+		in.maxSteps = n
+	}
+}
+
+// WithMaxDepth stops a run with TooDeep when a call would nest more than n function
+// calls deep.  It can't be turned off; n <= 0 restores DefaultMaxDepth.
+func WithMaxDepth(n int) Option {
+	return func(in *Interpreter) {
+		in.maxDepth = n
+	}
+}
+
+// WithMaxAlloc stops a run with TooMuchAlloc once the YARNs, BUKKITs and BUKKIT slots
+// it has made add up to more than n bytes.  It is a budget for the whole run, not a limit
+// on the memory in use at once: the bytes of a YARN still count once it's gone.
+// 0, the default, means no limit.
+func WithMaxAlloc(n int) Option {
+	return func(in *Interpreter) {
+		in.maxAlloc = n
+	}
+}
+
+// step counts a statement or loop iteration, and stops the run if it's over
+// its step limit or its context is done
+func (r *run) step() {
+	r.steps++
+	if r.maxSteps > 0 && r.steps > r.maxSteps {
+		raise(TooManySteps, "Ran more than %d steps", r.maxSteps)
+	}
+	select {
+	case <-r.ctx.Done():
+		r.canceled()
+	default:
+	}
+}
+
+// canceled stops the run because its context is done
+func (r *run) canceled() {
+	panic(&RuntimeError{Kind: Canceled, Msg: "Stopped: " + r.ctx.Err().Error(), Err: r.ctx.Err()})
+}
+
+// alloc counts the bytes of a new YARN, BUKKIT or slot, and stops the run if it's over budget
+func (r *run) alloc(n int) {
+	r.allocated += n
+	if r.maxAlloc > 0 && r.allocated > r.maxAlloc {
+		raise(TooMuchAlloc, "Allocated more than %d bytes", r.maxAlloc)
+	}
+}
+
+// allocCast counts what casting x to val made: a YARN, or the BUKKIT that NOOB became
+func (r *run) allocCast(x, val interface{}) interface{} {
+	if _, ok := val.(*Bukkit); ok && x == nil {
+		r.alloc(bukkitSize)
+	}
+	return r.allocYarn(val)
+}
+
+// allocYarn counts val if it's a YARN, and returns it
+func (r *run) allocYarn(val interface{}) interface{} {
+	if s, ok := val.(string); ok {
+		r.alloc(len(s))
+	}
+	return val
+}
@@ -0,0 +1,127 @@
+package lang
+
+import (
+	"context"
+	"lol/token"
+	"lol/vm"
+)
+
+// A namespace is one scope of variables.  Scopes nest inside the scope that
+// encloses them, up to the outermost scope of a frame: the global scope of
+// the program, or the local scope of a function call.  Lookups never leave the
+// frame, so a function can't see the variables of its caller or the globals.
+type namespace struct {
+	vars   map[string]interface{}
+	parent *namespace // enclosing scope, or nil at the top of the frame
+	frame  *frame
+}
+
+// A frame is the state of the program itself, or of a single function call
+type frame struct {
+	name   string      // function being run, or "" for the program
+	caller *frame      // frame that made the call, or nil for the program
+	depth  int         // number of calls in progress, counting this one
+	pos    token.Pos   // position of the statement being run
+	ret    interface{} // value given to FOUND YR
+	run    *run
+
+	// the functions I IZ calls: those of the program, or of the library being loaded
+	// or whose function is being run
+	funcs map[string]*function
+}
+
+// A run is the state of one execution of a program
+type run struct {
+	*Interpreter
+	ctx       context.Context
+	top       *frame    // innermost frame
+	steps     int       // statements and loop iterations run so far
+	allocated int       // bytes of YARN and slots made so far
+	loading   []loading // libraries being loaded, outermost first
+
+	machine *vm.Machine // running the program, if it was compiled to bytecode
+}
+
+// newFrame makes the outermost scope of a new frame called from the top frame
+// of the run, holding just its own IT.  The new frame becomes the top frame.
+func (r *run) newFrame(name string) *namespace {
+	return &namespace{
+		vars:  map[string]interface{}{"IT": nil},
+		frame: r.pushFrame(name),
+	}
+}
+
+// pushFrame makes a new frame, without any scope, the top frame.
+// It calls the same functions as the frame that was on top.
+func (r *run) pushFrame(name string) *frame {
+	depth, funcs := 0, r.funcs
+	if r.top != nil {
+		depth, funcs = r.top.depth+1, r.top.funcs
+	}
+	if depth > r.maxDepth {
+		raise(TooDeep, "Calls nested more than %d deep", r.maxDepth)
+	}
+	r.top = &frame{name: name, caller: r.top, depth: depth, run: r, funcs: funcs}
+	return r.top
+}
+
+// stack lists the frames of the run, innermost first
+func (r *run) stack() []StackFrame {
+	var stack []StackFrame
+	for f := r.top; f != nil; f = f.caller {
+		stack = append(stack, StackFrame{f.name, f.pos})
+	}
+	return stack
+}
+
+// newScope makes a scope nested inside ns, in the same frame
+func (ns *namespace) newScope() *namespace {
+	return &namespace{
+		vars:   make(map[string]interface{}),
+		parent: ns,
+		frame:  ns.frame,
+	}
+}
+
+// declare creates a variable in this scope, shadowing any of the same name in enclosing scopes
+func (ns *namespace) declare(ident string, val interface{}) {
+	ns.vars[ident] = val
+}
+
+// lookup finds the scope holding a variable
+func (ns *namespace) lookup(ident string) (*namespace, bool) {
+	for ; ns != nil; ns = ns.parent {
+		if _, ok := ns.vars[ident]; ok {
+			return ns, true
+		}
+	}
+	return nil, false
+}
+
+// assign sets the value of a variable in whichever scope holds it
+func (ns *namespace) assign(ident string, val interface{}) bool {
+	scope, ok := ns.lookup(ident)
+	if ok {
+		scope.vars[ident] = val
+	}
+	return ok
+}
+
+func (ns *namespace) getOrPanic(ident string) interface{} {
+	if scope, ok := ns.lookup(ident); ok {
+		return scope.vars[ident]
+	}
+	raise(UndefinedVar, "Reference to undefined variable: %s", ident)
+	return nil
+}
+
+func (ns *namespace) putOrPanic(ident string, val interface{}) {
+	if !ns.assign(ident, val) {
+		raise(UndefinedVar, "Assignment to undefined variable: %s", ident)
+	}
+}
+
+// setIT stores the value of a bare expression in the IT of the frame
+func (ns *namespace) setIT(val interface{}) {
+	ns.putOrPanic("IT", val)
+}
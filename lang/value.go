@@ -0,0 +1,57 @@
+package lang
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Value is a Lolcode value as Go sees it: nil for NOOB, or a bool, int64, float64,
+// string or *Bukkit
+type Value = interface{}
+
+// Yarn is the YARN that MAEK casts a value to, so a NUMBAR has two decimal places,
+// as VISIBLE prints it.  ok is false for a value with no YARN, such as a *Bukkit.
+func Yarn(val Value) (s string, ok bool) {
+	switch val.(type) {
+	case nil, bool, int64, float64, string:
+		return yarn(val, true), true
+	}
+	return "", false
+}
+
+// toGo gives a value found by a lookup to Go, leaving out a function, which only
+// Lolcode can call
+func toGo(val interface{}, ok bool) (Value, bool) {
+	if _, isFunc := val.(*function); isFunc {
+		return nil, false
+	}
+	return val, ok
+}
+
+// fromGo converts a Go value to the Lolcode value it stands for
+func fromGo(val interface{}) (interface{}, error) {
+	switch val := val.(type) {
+	case nil:
+		return nil, nil
+	case *Bukkit:
+		return val, nil
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%v is too big for a NUMBR", val)
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	return nil, fmt.Errorf("%T has no Lolcode type", val)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const maxHistory = 1000
+
+var errInterrupted = errors.New("interrupted")
+
+// A lineReader reads the lines typed at the REPL.  On a terminal, the line can be
+// edited as it's typed, and the up and down arrows step through the history of
+// lines entered before, which is kept in a file between sessions.
+type lineReader struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	history  []string
+	histFile string
+}
+
+func newLineReader(in *bufio.Reader, out io.Writer, histFile string) *lineReader {
+	lr := &lineReader{in: in, out: out, fd: int(os.Stdin.Fd()), histFile: histFile}
+	if data, err := os.ReadFile(histFile); err == nil {
+		lr.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lr.history) > maxHistory {
+			lr.history = lr.history[len(lr.history)-maxHistory:]
+		}
+	}
+	return lr
+}
+
+// readLine prompts for a line and returns it without its line ending.
+// Ctrl-C abandons the line with errInterrupted, and Ctrl-D on an empty line gives io.EOF.
+func (lr *lineReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(lr.out, prompt)
+	restore, err := makeRaw(lr.fd)
+	if err != nil { // not a terminal, so no editing
+		line, err := lr.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+	line, err := lr.edit(prompt)
+	if err == nil {
+		lr.remember(line)
+	}
+	return line, err
+}
+
+// edit reads keys from a terminal in raw mode until the line is entered
+func (lr *lineReader) edit(prompt string) (string, error) {
+	var buf []rune
+	pos := 0                // of the cursor in buf
+	hist := len(lr.history) // line of history being shown, or len(lr.history) for the new line
+	var newLine []rune      // the new line, while history is being shown
+	redraw := func() {
+		fmt.Fprintf(lr.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(lr.out, "\x1b[%dD", back)
+		}
+	}
+	showHistory := func(i int) {
+		if hist == len(lr.history) {
+			newLine = buf
+		}
+		hist = i
+		if hist == len(lr.history) {
+			buf = newLine
+		} else {
+			buf = []rune(lr.history[hist])
+		}
+		pos = len(buf)
+	}
+	for {
+		r, _, err := lr.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(lr.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(lr.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(lr.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf, pos = append([]rune{}, buf[pos:]...), 0
+		case 8, 127: // backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 27: // escape sequence, for the arrows and such
+			switch lr.escape() {
+			case "[A", "OA": // up
+				if hist > 0 {
+					showHistory(hist - 1)
+				}
+			case "[B", "OB": // down
+				if hist < len(lr.history) {
+					showHistory(hist + 1)
+				}
+			case "[C", "OC": // right
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD": // left
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+			case "[F", "OF", "[4~":
+				pos = len(buf)
+			case "[3~": // delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if !unicode.IsPrint(r) && r != '\t' {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// escape reads the rest of an escape sequence after the ESC
+func (lr *lineReader) escape() string {
+	var seq strings.Builder
+	for {
+		b, err := lr.in.ReadByte()
+		if err != nil {
+			return seq.String()
+		}
+		seq.WriteByte(b)
+		// a sequence ends with a letter or ~, after the [ or O that starts it
+		if seq.Len() > 1 && (b == '~' || unicode.IsLetter(rune(b))) {
+			return seq.String()
+		}
+		if seq.Len() == 1 && b != '[' && b != 'O' {
+			return seq.String()
+		}
+	}
+}
+
+// remember adds a line to the history, unless it's blank or the same as the last one.
+// Failing to save the history file isn't worth interrupting the session for.
+func (lr *lineReader) remember(line string) {
+	if strings.TrimSpace(line) == "" ||
+		len(lr.history) > 0 && lr.history[len(lr.history)-1] == line {
+		return
+	}
+	lr.history = append(lr.history, line)
+	if lr.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(lr.histFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
@@ -1,9 +1,75 @@
 package main
 
 import (
-	//"lol/token"
-	//"fmt"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"lol/lang"
+	"lol/parser"
+	"os"
+	"path/filepath"
 )
 
+var interactive = flag.Bool("i", false, "read statements from stdin and run each as it is entered")
+
+// lol runs a Lolcode program read from the file named by its first argument,
+// or from stdin if no file is given.  With -i, or with no file and a terminal on
+// stdin, it starts a REPL instead, after running the file if one is given.
+// CAN HAS finds libraries in the directory of the file, or the current directory,
+// then in those listed in $LOLPATH.
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lol [-i] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	stdin := bufio.NewReader(os.Stdin)
+	file := flag.Arg(0)
+	libPath := append([]string{filepath.Dir(file)}, filepath.SplitList(os.Getenv("LOLPATH"))...)
+	interp := lang.New(lang.WithStdin(stdin), lang.WithLibraryPath(libPath...))
+	if file == "" && (*interactive || isTerminal(int(os.Stdin.Fd()))) {
+		repl(interp, stdin)
+		return
+	}
+
+	var in io.Reader = stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	prog, err := interp.CompileFile(file, in)
+	if err != nil {
+		fail(err)
+	}
+	if err := prog.Run(context.Background()); err != nil {
+		fail(err)
+	}
+	if *interactive {
+		repl(interp, stdin)
+	}
+}
+
+func fail(err error) {
+	report(err)
+	os.Exit(1)
+}
+
+// report prints an error from lang on stderr
+func report(err error) {
+	if errs, ok := err.(parser.ErrorList); ok {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, "lol:", e)
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, "lol:", err)
+	if rtErr, ok := err.(*lang.RuntimeError); ok {
+		fmt.Fprint(os.Stderr, rtErr.Trace())
+	}
 }
@@ -3,7 +3,8 @@ package parser
 import (
 	"fmt"
 	"lol/token"
-	"os"
+	"sort"
+	"strings"
 )
 
 // Dialect is a collection of parser nodes that form a language
@@ -14,8 +15,9 @@ type Dialect struct {
 }
 
 type parseNode struct {
-	name  string
-	rules []rule
+	rules   []rule
+	recover bool // recover from errors by skipping through a sync token
+	sync    int
 }
 
 // NewDialect constructs a Dialect.
@@ -32,23 +34,48 @@ func NewDialect(t int, m int) *Dialect {
 }
 
 // Name assigns a name to a given grammar node.  This name will be used to format syntax errors.
+// Unnamed nodes are described by the tokens they may start with.
 func (d *Dialect) Name(i int, name string) {
 	d.names[i-d.numToks] = name
 }
 
-// Parser is the signature of the functions that must be supplied to Rule and RepRule
+// Recover makes node i recover from syntax errors so that parsing can go on to find more of them.
+// After an error, tokens are skipped through the next token of type t and node i is treated as parsed.
+// Typically i is a statement and t ends a line.
+func (d *Dialect) Recover(i int, t int) {
+	node := &d.nodes[i-d.numToks]
+	node.recover, node.sync = true, t
+}
+
+// Parser is the signature of the functions that must be supplied to Rule and RepRule.
+// A Parser may reject the nodes it is given by returning an error, which becomes a
+// SyntaxError at the start of the rule unless it is a *SyntaxError already.
 type Parser func(args []interface{}) interface{}
 
+// PosParser is a Parser that is also told the position of the first token of its rule
+type PosParser func(pos token.Pos, args []interface{}) interface{}
+
 // Rule establishes a new parseRule for node i which will parse nodes off a stream
 // as determined by the given args, then apply the given parser function.
 func (d *Dialect) Rule(i int, p Parser, args ...int) {
-	d.rule(i, false, p, args)
+	d.rule(i, false, ignorePos(p), args)
 }
 
 // RepRule is similar to Rule but the sequence of nodes will be parsed as many times as possible (0 is ok).
 // p is applied to each cycle and a slice of results is forwarded up.
 func (d *Dialect) RepRule(i int, p Parser, args ...int) {
-	d.rule(i, true, p, args)
+	d.rule(i, true, ignorePos(p), args)
+}
+
+// PosRule is similar to Rule but p also receives the source position where the rule began.
+func (d *Dialect) PosRule(i int, p PosParser, args ...int) {
+	d.rule(i, false, p, args)
+}
+
+func ignorePos(p Parser) PosParser {
+	return func(pos token.Pos, args []interface{}) interface{} {
+		return p(args)
+	}
 }
 
 // Represents a rule by which a node may be parsed.  A single node allows multiple
@@ -56,89 +83,302 @@ func (d *Dialect) RepRule(i int, p Parser, args ...int) {
 type rule struct {
 	nodes       []int
 	isRepeating bool
-	parse       Parser
+	parse       PosParser
 }
 
-func (d *Dialect) rule(i int, isRepeating bool, p Parser, args []int) {
+func (d *Dialect) rule(i int, isRepeating bool, p PosParser, args []int) {
 	node := &d.nodes[i-d.numToks]
 	node.rules = append(node.rules, rule{
 		args, isRepeating, p,
 	})
 }
 
-// Parse will parse a channel of supplied tokens according to the rules of the dialect
-func (d *Dialect) Parse(start int, tokens <-chan token.Token,
-) (*token.Token, interface{}, bool) {
-	defer func() {
-		if r := recover(); r != nil {
-			os.Stderr.WriteString(fmt.Sprintf("%v", r))
+// SyntaxError describes why a stream of tokens could not be parsed
+type SyntaxError struct {
+	Pos      token.Pos
+	Found    token.Token // the offending token
+	Expected []string    // names of the tokens or grammar nodes that could have come instead
+	Msg      string      // if set, explains the error in place of Found and Expected
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Msg != "" {
+		return fmt.Sprintf("%v: %s", e.Pos, e.Msg)
+	}
+	found := token.TypeName(e.Found.Type)
+	switch e.Found.Type {
+	case token.Err: // the lexer has already explained itself
+		return fmt.Sprintf("%v: %v", e.Pos, e.Found)
+	case token.Literal, token.Ident, token.Type:
+		found = fmt.Sprintf("%s %v", found, e.Found)
+	}
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("%v: unexpected %s", e.Pos, found)
+	}
+	return fmt.Sprintf("%v: unexpected %s, expected %s", e.Pos, found, orList(e.Expected))
+}
+
+// orList formats a list as "a, b or c"
+func orList(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
+
+// ErrorList holds every SyntaxError found in a parse, in order of position
+type ErrorList []*SyntaxError
+
+// Error lists the errors one per line
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap allows errors.As to find the first *SyntaxError
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
+
+func (l ErrorList) sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Pos, l[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
 		}
-	}()
+		return a.Col < b.Col
+	})
+}
+
+// Parse will parse a channel of supplied tokens according to the rules of the dialect.
+// It returns the first token following the parsed node and the parsed value.
+// If the tokens don't form the start node, it returns an ErrorList of the syntax errors found,
+// which holds more than one only if nodes of the dialect Recover from errors.
+func (d *Dialect) Parse(start int, tokens <-chan token.Token,
+) (*token.Token, interface{}, error) {
+	p := &parsing{d: d, more: tokens}
 	first := <-tokens
-	return d.parseNode(start, &first, tokens)
+	curr, val, ok, err := p.parseNode(start, &first)
+	switch {
+	case err != nil:
+		p.errs = append(p.errs, err)
+	case !ok:
+		p.errs = append(p.errs, d.unexpected(curr, start))
+	}
+	if len(p.errs) > 0 {
+		p.errs.sort()
+		return curr, nil, p.errs
+	}
+	return curr, val, nil
+}
+
+// parsing is the state of a single call to Parse
+type parsing struct {
+	d    *Dialect
+	more <-chan token.Token
+	errs ErrorList // errors recovered from so far
 }
 
-func (d *Dialect) parseNode(id int, curr *token.Token, more <-chan token.Token,
-) (*token.Token, interface{}, bool) {
+// Once a node fails to parse, this stands in for its value.
+// Parsers are never given it; rules built from it are failed too.
+type failedNode struct{}
+
+var failed = failedNode{}
+
+// hasFailed tells if any of the values, or the values in a repeated rule, has failed
+func hasFailed(vals []interface{}) bool {
+	for _, v := range vals {
+		switch v := v.(type) {
+		case failedNode:
+			return true
+		case []interface{}:
+			if hasFailed(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unexpected builds the error for finding curr where one of nodes ids should have started
+func (d *Dialect) unexpected(curr *token.Token, ids ...int) *SyntaxError {
+	var expected []string
+	for _, id := range ids {
+		expected = d.expected(id, expected)
+	}
+	return &SyntaxError{
+		Pos:      curr.Pos,
+		Found:    *curr,
+		Expected: expected,
+	}
+}
+
+// expected names what node id may start with: its own name if it has one,
+// otherwise the names of whatever its rules may start with.
+func (d *Dialect) expected(id int, names []string) []string {
+	if id < d.numToks {
+		return appendUnique(names, token.TypeName(id))
+	}
+	if name := d.names[id-d.numToks]; name != "" {
+		return appendUnique(names, name)
+	}
+	for _, r := range d.nodes[id-d.numToks].rules {
+		for _, n := range r.nodes {
+			if n >= 0 {
+				names = d.expected(n, names)
+				break
+			}
+			names = d.expected(-n, names) // an optional node may be followed by the next
+		}
+	}
+	return names
+}
+
+func (d *Dialect) isRepeating(id int) bool {
+	if id < d.numToks {
+		return false
+	}
+	for _, r := range d.nodes[id-d.numToks].rules {
+		if r.isRepeating {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// parseNode returns ok if the node was parsed.  If it wasn't, and no tokens were consumed,
+// err is nil and another rule may be tried.
+func (p *parsing) parseNode(id int, curr *token.Token,
+) (*token.Token, interface{}, bool, *SyntaxError) {
+	d := p.d
 	// base case is a single token
 	if id < d.numToks {
 		if curr.Type == id {
-			next := <-more
-			return &next, curr.Value, true
+			next := <-p.more
+			return &next, curr.Value, true, nil
 		}
-		return curr, nil, false
+		return curr, nil, false, nil
 	}
 	// recursively try each of the rules until we find a winner
 	node := &d.nodes[id-d.numToks]
 	for _, r := range node.rules {
-		if curr, val, ok := d.parseRule(&r, curr, more); ok {
-			return curr, val, true
+		curr, val, ok, err := p.parseRule(&r, curr)
+		if err != nil && node.recover && curr.Type != token.EOF {
+			p.errs = append(p.errs, err)
+			return p.skipThrough(node.sync, curr), failed, true, nil
+		}
+		if ok || err != nil {
+			return curr, val, ok, err
 		}
 	}
-	return curr, nil, false
+	// a recovering node also gets past tokens the lexer couldn't make sense of
+	if node.recover && curr.Type == token.Err {
+		p.errs = append(p.errs, d.unexpected(curr, id))
+		return p.skipThrough(node.sync, curr), failed, true, nil
+	}
+	return curr, nil, false, nil
+}
+
+// skipThrough discards tokens up to and including the next of type sync
+func (p *parsing) skipThrough(sync int, curr *token.Token) *token.Token {
+	for curr.Type != sync && curr.Type != token.EOF {
+		next := <-p.more
+		curr = &next
+	}
+	if curr.Type == sync {
+		next := <-p.more
+		curr = &next
+	}
+	return curr
 }
 
 // Attempt to parse the given rule
-func (d *Dialect) parseRule(r *rule, curr *token.Token, more <-chan token.Token,
-) (*token.Token, interface{}, bool) {
+func (p *parsing) parseRule(r *rule, curr *token.Token,
+) (*token.Token, interface{}, bool, *SyntaxError) {
 	if r.isRepeating {
 		var result []interface{}
 		for {
-			cur, val, ok := d.parseRuleSingle(r, curr, more)
+			cur, val, ok, err := p.parseRuleSingle(r, curr)
 			curr = cur // If we don't do this, inner var curr shadows parameter curr
+			if err != nil {
+				return curr, nil, false, err
+			}
 			if !ok {
-				return curr, result, true
+				return curr, result, true, nil
 			}
 			result = append(result, val)
 		}
 	}
-	return d.parseRuleSingle(r, curr, more)
+	return p.parseRuleSingle(r, curr)
 }
 
 // Attempt to parse a single pass of the given rule
-func (d *Dialect) parseRuleSingle(r *rule, curr *token.Token, more <-chan token.Token,
-) (*token.Token, interface{}, bool) {
+func (p *parsing) parseRuleSingle(r *rule, curr *token.Token,
+) (*token.Token, interface{}, bool, *SyntaxError) {
+	d := p.d
 	var vals []interface{}
+	var skipped []int // nodes that could have been parsed after the last one
+	pos := curr.Pos
 	for i := 0; i < len(r.nodes); i++ {
 		id, optional := r.nodes[i], false
 		if id < 0 {
 			id = -id
 			optional = true
 		}
-		cur, val, ok := d.parseNode(id, curr, more)
+		cur, val, ok, err := p.parseNode(id, curr)
 		curr = cur
 		switch {
+		case err != nil:
+			return curr, nil, false, err
 		case ok:
 			if vals == nil {
 				vals = make([]interface{}, len(r.nodes))
 			}
 			vals[i] = val
-		case !optional:
+			skipped = skipped[:0]
+			if d.isRepeating(id) {
+				skipped = append(skipped, id) // it could have gone on repeating
+			}
+		case optional:
+			skipped = append(skipped, id)
+		default:
 			if vals == nil {
-				return curr, nil, false
+				return curr, nil, false, nil
 			}
-			panic(fmt.Sprintln("Unexpected token", curr))
+			return curr, nil, false, d.unexpected(curr, append(skipped, id)...)
 		}
 	}
-	return curr, r.parse(vals), true
+	if hasFailed(vals) {
+		return curr, failed, true, nil
+	}
+	// The rule's tokens are all consumed, so a parser's error can be recovered from right away
+	switch val := r.parse(pos, vals).(type) {
+	case *SyntaxError:
+		p.errs = append(p.errs, val)
+		return curr, failed, true, nil
+	case error:
+		p.errs = append(p.errs, &SyntaxError{Pos: pos, Msg: val.Error()})
+		return curr, failed, true, nil
+	default:
+		return curr, val, true, nil
+	}
 }
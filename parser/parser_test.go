@@ -2,6 +2,8 @@ package parser
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"lol/token"
 	"strings"
 	"testing"
@@ -12,6 +14,8 @@ const (
 	ExprList
 	Sum
 	Prod
+	Line
+	Lines
 	NumNodes
 )
 
@@ -39,6 +43,16 @@ func init() {
 	d.Rule(Expr, sum, token.SUMOF, ExprList, -token.MKAY)
 	d.Rule(Expr, prod, token.PRODUKTOF, ExprList, -token.MKAY)
 	d.RepRule(ExprList, fetchSecond, -token.AN, Expr)
+
+	// Lines of expressions, where errors are recovered from at the end of the line
+	d.Rule(Line, func(args []interface{}) interface{} {
+		if args[0].(int64) < 0 {
+			return fmt.Errorf("negative")
+		}
+		return args[0]
+	}, Expr, token.EOL)
+	d.Recover(Line, token.EOL)
+	d.RepRule(Lines, fetchFirst, Line)
 }
 
 func TestMath(t *testing.T) {
@@ -60,11 +74,11 @@ func TestMath(t *testing.T) {
 		reader := bufio.NewReader(strings.NewReader(tc.code))
 		tokens := make(chan token.Token, 100)
 		go token.EmitTokens(reader, tokens)
-		cur, val, ok := d.Parse(Expr, tokens)
+		cur, val, err := d.Parse(Expr, tokens)
 		switch {
-		case !ok:
-			t.Fatalf("Parse unsuccessful")
-		case *cur != token.Token{token.EOL, token.EOLPhrase}:
+		case err != nil:
+			t.Fatalf("Parse unsuccessful: %v", err)
+		case cur.Type != token.EOL:
 			t.Fatalf("Expected token mismatch")
 		case val.(int64) != tc.expected:
 			t.Fatalf("Parse returned %d, expected %d", val.(int64), tc.expected)
@@ -77,8 +91,8 @@ func TestPanic(t *testing.T) {
 	reader := bufio.NewReader(strings.NewReader(str))
 	tokens := make(chan token.Token, 100)
 	go token.EmitTokens(reader, tokens)
-	_, _, ok := d.Parse(Expr, tokens)
-	if ok {
+	_, _, err := d.Parse(Expr, tokens)
+	if err == nil {
 		t.Fatalf("Expected failure")
 	}
 }
@@ -88,8 +102,84 @@ func TestPanic2(t *testing.T) {
 	reader := bufio.NewReader(strings.NewReader(str))
 	tokens := make(chan token.Token, 100)
 	go token.EmitTokens(reader, tokens)
-	_, _, ok := d.Parse(Expr, tokens)
-	if ok {
+	_, _, err := d.Parse(Expr, tokens)
+	if err == nil {
 		t.Fatalf("Expected failure")
 	}
 }
+
+func TestSyntaxError(t *testing.T) {
+	type testCase struct {
+		code     string
+		expected string
+	}
+	testCases := []testCase{
+		{"SUM OF 3 AN\n", "1:12: unexpected End-of-line, expected expression"},
+		{"SUM OF 3 4 5 MKAY MKAY\n", ""},
+		{"  PRODUKT OF 3 AN SUM\n", "1:19: End-of-line: expected OF"},
+		{"MKAY\n", "1:1: unexpected MKAY, expected expression"},
+		{"SUM OF 3 AN SUM OF 3 AN", "1:24: unexpected End-of-line, expected expression"},
+		{"", "1:1: unexpected End-of-file, expected expression"},
+	}
+	d.Name(Expr, "expression")
+	for _, tc := range testCases {
+		reader := bufio.NewReader(strings.NewReader(tc.code))
+		tokens := make(chan token.Token, 100)
+		go token.EmitTokens(reader, tokens)
+		_, _, err := d.Parse(Expr, tokens)
+		if tc.expected == "" {
+			if err != nil {
+				t.Fatalf("%q: unexpected error %v", tc.code, err)
+			}
+			continue
+		}
+		var synErr *SyntaxError
+		if !errors.As(err, &synErr) || err.Error() != tc.expected {
+			t.Fatalf("%q: expected error %q, got %v", tc.code, tc.expected, err)
+		}
+	}
+
+	// without a name, an expression is described by what it starts with
+	d.Name(Expr, "")
+	reader := bufio.NewReader(strings.NewReader("MKAY\n"))
+	tokens := make(chan token.Token, 100)
+	go token.EmitTokens(reader, tokens)
+	_, _, err := d.Parse(Expr, tokens)
+	if expected := "1:1: unexpected MKAY, expected literal, SUM OF or PRODUKT OF"; err == nil || err.Error() != expected {
+		t.Fatalf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	code := `SUM OF 1 AN 2
+PRODUKT OF 3 AN MKAY MKAY
+SUM OF 4 AN 5
+SUM OF -10 AN 1 AN 3
+PRODUKT OF
+SUM 6
+`
+	reader := bufio.NewReader(strings.NewReader(code))
+	tokens := make(chan token.Token, 100)
+	go token.EmitTokens(reader, tokens)
+	cur, _, err := d.Parse(Lines, tokens)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+	expected := []string{
+		"2:17: unexpected MKAY, expected literal, SUM OF or PRODUKT OF",
+		"4:1: negative",
+		"6:1: 6: expected OF",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), err)
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Fatalf("Expected error %q, got %q", expected[i], e)
+		}
+	}
+	if cur.Type != token.EOF {
+		t.Fatalf("Expected to parse through to the end, stopped at %v", cur)
+	}
+}
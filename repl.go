@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"lol/lang"
+	"lol/parser"
+	"lol/token"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	prompt     = "lol> "
+	morePrompt = "...> " // while a statement is waiting for the rest of its lines
+)
+
+// repl reads statements from stdin and runs each as soon as it is complete, all in
+// the globals of interp.  A statement that is a bare expression has its value printed.
+func repl(interp *lang.Interpreter, stdin *bufio.Reader) {
+	lines := newLineReader(stdin, os.Stdout, historyFile())
+	var src strings.Builder // lines of the statement being entered
+	for {
+		p := prompt
+		if src.Len() > 0 {
+			p = morePrompt
+		}
+		line, err := lines.readLine(p)
+		switch {
+		case err == errInterrupted:
+			src.Reset()
+			continue
+		case err != nil:
+			if err != io.EOF {
+				report(err)
+			}
+			return
+		}
+		if src.Len() == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		src.WriteString(line + "\n")
+		// The lines so far are lexed again and compiled as a Block, rather than each
+		// Statement parsed from one stream of tokens as lines come in: Parse reads the
+		// token after a statement before returning it, so it would wait for the next
+		// line before running this one.  A Block also takes a line of statements
+		// separated by commas, and is compiled for the interpreter's backend.
+		prog, err := interp.CompileStatements(strings.NewReader(src.String()))
+		if incomplete(err) {
+			continue
+		}
+		code := src.String()
+		src.Reset()
+		if err != nil {
+			report(err)
+			continue
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = prog.Run(ctx)
+		stop()
+		if err != nil {
+			report(err)
+			continue
+		}
+		if it, ok := interp.Get("IT"); ok && isExpr(code) { // not a function from a slot
+			fmt.Println(show(it))
+		}
+	}
+}
+
+// incomplete tells if err only complains that the statements ended too soon,
+// which more lines could put right
+func incomplete(err error) bool {
+	errs, ok := err.(parser.ErrorList)
+	if !ok {
+		return false
+	}
+	for _, e := range errs {
+		if e.Found.Type != token.EOF {
+			return false
+		}
+	}
+	return true
+}
+
+// isExpr tells if code is a single bare expression
+func isExpr(code string) bool {
+	tokens := make(chan token.Token, 100)
+	go token.EmitTokens(bufio.NewReader(strings.NewReader(code)), tokens)
+	defer func() {
+		for range tokens {
+		}
+	}()
+	cur, _, err := lang.D.Parse(lang.Expr, tokens)
+	return err == nil && cur.Type == token.EOL && (<-tokens).Type == token.EOF
+}
+
+var yarnEscapes = strings.NewReplacer(":", "::", `"`, `:"`, "\n", ":)", "\t", ":>", "\a", ":o")
+
+// show formats a value the way it would be written in Lolcode
+func show(val lang.Value) string {
+	switch val := val.(type) {
+	case nil:
+		return "NOOB"
+	case bool:
+		if val {
+			return "WIN"
+		}
+		return "FAIL"
+	case string:
+		return `"` + yarnEscapes.Replace(val) + `"`
+	case *lang.Bukkit: // only the names of its slots, which may hold the BUKKIT itself
+		return "BUKKIT (" + strings.Join(val.Slots(), ", ") + ")"
+	}
+	if s, ok := lang.Yarn(val); ok { // a number, as VISIBLE prints it
+		return s
+	}
+	return fmt.Sprint(val)
+}
+
+// historyFile is where lines entered at the prompt are saved, or "" if there's no home directory
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".lol_history")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"lol/lang"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	for code, expected := range map[string]bool{
+		"VISIBLE 1\n":                          false,
+		"BOTH SAEM 1 AN 1, O RLY?\n":           true,
+		"O RLY?\nYA RLY\n\tVISIBLE 1\n":        true,
+		"O RLY?\nYA RLY\n\tVISIBLE SUM OF 1\n": false,
+		"HOW IZ I F\n":                         true,
+		"HOW IZ I F\nIF U SAY SO\n":            false,
+		"OIC\n":                                false,
+		"VISIBLE \"HAI\n":                      false,
+	} {
+		_, err := lang.New().CompileStatements(strings.NewReader(code))
+		if incomplete(err) != expected {
+			t.Errorf("%q: expected incomplete to be %v, error was %v", code, expected, err)
+		}
+	}
+}
+
+func TestIsExpr(t *testing.T) {
+	for code, expected := range map[string]bool{
+		"X\n":                 true,
+		"SUM OF 1 AN 2\n":     true,
+		"I IZ F YR 1 MKAY\n":  true,
+		"X R 3\n":             false,
+		"VISIBLE X\n":         false,
+		"X, VISIBLE X\n":      false,
+		"I HAS A X ITZ 1\n":   false,
+		"SUM OF 1 AN 2, X\n":  false,
+		"O RLY?\nYA RLY\nOIC": false,
+	} {
+		if isExpr(code) != expected {
+			t.Errorf("%q: expected isExpr to be %v", code, expected)
+		}
+	}
+}
+
+func TestShow(t *testing.T) {
+	cat := lang.NewBukkit()
+	cat.Set("NAME", "TIBBLES")
+	cat.Set("LIVES", 9)
+	for _, tc := range []struct {
+		val      lang.Value
+		expected string
+	}{
+		{nil, "NOOB"},
+		{true, "WIN"},
+		{false, "FAIL"},
+		{int64(42), "42"},
+		{3.5, "3.50"},
+		{-0.125, "-0.12"},
+		{"O HAI\n\"KITTEH\": 1", `"O HAI:):"KITTEH:":: 1"`},
+		{lang.NewBukkit(), "BUKKIT ()"},
+		{cat, "BUKKIT (NAME, LIVES)"},
+	} {
+		if s := show(tc.val); s != tc.expected {
+			t.Errorf("show(%#v) is %s, expected %s", tc.val, s, tc.expected)
+		}
+	}
+}
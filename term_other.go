@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func isTerminal(fd int) bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// makeRaw isn't supported here, so lines are read without editing or history
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("line editing is not supported on this system")
+}
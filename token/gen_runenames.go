@@ -0,0 +1,64 @@
+//go:build ignore
+
+// gen_runenames writes runenames.gz, the table of Unicode character names used by
+// the :[<name>] YARN escape, from a copy of the Unicode Character Database's UnicodeData.txt:
+//
+//	go run gen_runenames.go UnicodeData.txt
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Names made of one of these prefixes and a code point are decoded by the token package itself
+var algorithmic = []string{
+	"CJK UNIFIED IDEOGRAPH-",
+	"CJK COMPATIBILITY IDEOGRAPH-",
+	"TANGUT IDEOGRAPH-",
+	"KHITAN SMALL SCRIPT CHARACTER-",
+	"NUSHU CHARACTER-",
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: go run gen_runenames.go UnicodeData.txt")
+		os.Exit(2)
+	}
+	in, err := os.Open(os.Args[1])
+	check(err)
+	defer in.Close()
+	out, err := os.Create("runenames.gz")
+	check(err)
+	defer out.Close()
+	zw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	check(err)
+
+	scanner := bufio.NewScanner(in)
+lines:
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ";")
+		if len(fields) < 2 || strings.HasPrefix(fields[1], "<") {
+			continue // ranges and control characters have no name of their own
+		}
+		for _, prefix := range algorithmic {
+			if strings.HasPrefix(fields[1], prefix) {
+				continue lines
+			}
+		}
+		_, err := fmt.Fprintf(zw, "%s;%s\n", fields[0], fields[1])
+		check(err)
+	}
+	check(scanner.Err())
+	check(zw.Close())
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
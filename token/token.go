@@ -5,36 +5,59 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Token is key/value pair with a int key and interface{} value
+// Token is key/value pair with a int key and interface{} value,
+// tagged with the position it was read from
 type Token struct {
 	Type  int
 	Value interface{}
+	Pos   Pos
 }
 
 func (t Token) String() string {
 	return fmt.Sprint(t.Value)
 }
 
+// Pos is a location in Lolcode source.  Line and Col count from 1; Col counts bytes.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+// String formats the position as file:line:col, leaving out the file if it is unknown
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
 // Exported token types
 const (
 	Err = iota
 	TokHAI
 	KTHXBYE
 	Literal
+	InterpYarn
 	Ident
+	Type
 	EOL
+	EOF
 	IHASA
 	ITZ
 	R
 	MAEK
-	ISNOW
-	ANOOB
-	ATROOF
-	ANUMBR
-	ANUMBAR
-	AYARN
+	A
+	ISNOWA
+	HASA
+	SLOTZ
+	IZ
+	SRS
+	LIEKA
 	IIZ
 	BOTHSAEM
 	DIFFRINT
@@ -54,10 +77,35 @@ const (
 	SMOOSH
 	AN
 	MKAY
+	VISIBLE
+	GIMMEH
+	BANG
+	ORLY
+	YARLY
+	MEBBE
+	NOWAI
+	OIC
+	WTF
+	OMG
+	OMGWTF
+	GTFO
+	IMINYR
+	IMOUTTAYR
+	UPPIN
+	NERFIN
+	YR
+	TIL
+	WILE
+	HOWIZI
+	HOWIZ
+	IFUSAYSO
+	FOUNDYR
+	CANHAS
+	QUESTION
 	NumTokens
 )
 
-var phraseRoot = initPhrases([]phraseInit{
+var phrases = []phraseInit{
 	{EOL, EOLPhrase},
 	{TokHAI, "HAI"},
 	{KTHXBYE, "KTHXBYE"},
@@ -65,12 +113,13 @@ var phraseRoot = initPhrases([]phraseInit{
 	{ITZ, "ITZ"},
 	{R, "R"},
 	{MAEK, "MAEK"},
-	{ISNOW, "IS NOW"},
-	{ANOOB, "A NOOB"},
-	{ATROOF, "A TROOF"},
-	{ATROOF, "A YARN"},
-	{ATROOF, "A NUMBR"},
-	{ATROOF, "A NUMBAR"},
+	{A, "A"},
+	{ISNOWA, "IS NOW A"},
+	{HASA, "HAS A"},
+	{SLOTZ, "'Z"},
+	{IZ, "IZ"},
+	{SRS, "SRS"},
+	{LIEKA, "LIEK A"},
 	{IIZ, "I IZ"},
 	{BOTHSAEM, "BOTH SAEM"},
 	{DIFFRINT, "DIFFRINT"},
@@ -90,7 +139,69 @@ var phraseRoot = initPhrases([]phraseInit{
 	{SMOOSH, "SMOOSH"},
 	{AN, "AN"},
 	{MKAY, "MKAY"},
-})
+	{VISIBLE, "VISIBLE"},
+	{GIMMEH, "GIMMEH"},
+	{BANG, "!"},
+	{ORLY, "O RLY?"},
+	{YARLY, "YA RLY"},
+	{MEBBE, "MEBBE"},
+	{NOWAI, "NO WAI"},
+	{OIC, "OIC"},
+	{WTF, "WTF?"},
+	{OMG, "OMG"},
+	{OMGWTF, "OMGWTF"},
+	{GTFO, "GTFO"},
+	{IMINYR, "IM IN YR"},
+	{IMOUTTAYR, "IM OUTTA YR"},
+	{UPPIN, "UPPIN"},
+	{NERFIN, "NERFIN"},
+	{YR, "YR"},
+	{TIL, "TIL"},
+	{WILE, "WILE"},
+	{HOWIZI, "HOW IZ I"},
+	{HOWIZ, "HOW IZ"},
+	{IFUSAYSO, "IF U SAY SO"},
+	{FOUNDYR, "FOUND YR"},
+	{CANHAS, "CAN HAS"},
+	{QUESTION, "?"},
+}
+
+var phraseRoot = initPhrases(phrases)
+
+// phraseWords are the words of phrases, such as the RLY? of O RLY?, which the scanner leaves whole
+var phraseWords = func() map[string]bool {
+	words := make(map[string]bool)
+	for _, p := range phrases {
+		for _, w := range strings.Split(p.phrase, " ") {
+			words[w] = true
+		}
+	}
+	return words
+}()
+
+// Names of the token types that aren't phrases
+var typeNames = map[int]string{
+	Err:        "error",
+	Literal:    "literal",
+	InterpYarn: "YARN",
+	Ident:      "identifier",
+	Type:       "type",
+	EOF:        EOFPhrase,
+}
+
+// TypeName describes a token type in syntax errors: the phrase for a keyword,
+// or what sort of token it is for the rest.
+func TypeName(t int) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	for _, p := range phrases {
+		if p.t == t {
+			return p.phrase
+		}
+	}
+	return fmt.Sprintf("token %d", t)
+}
 
 type phraseNode struct {
 	t     int
@@ -134,114 +245,233 @@ func initPhrase(root *phraseNode, t int, words []string, msg string) {
 // How EOL is displayed in errors and such
 const EOLPhrase = "End-of-line"
 
+// How EOF is displayed in errors and such
+const EOFPhrase = "End-of-file"
+
+// fragment is a single word of source and where it was found
+type fragment struct {
+	text string
+	pos  Pos
+}
+
 // emitFragments reads from a bufio.Reader and emits string fragments on the given channel,
-// omitting all comments and converting line separators "," into "\n" fragments
-func emitFragments(reader *bufio.Reader, out chan<- string) {
+// omitting all comments and converting line separators "," into "\n" fragments.
+// A YARN literal is emitted as one fragment, quotes included.
+func emitFragments(file string, reader *bufio.Reader, out chan<- fragment) {
 	defer close(out)
-	insideComment := false
-txtLine:
-	for {
+	s := scanner{out: out}
+	for lineNum := 1; ; lineNum++ {
 		txt, err := reader.ReadString('\n')
-		if err != nil {
+		if err != nil && txt == "" {
+			// a final line without a newline is still read;
+			// an empty fragment marks the end of input
+			out <- fragment{"", Pos{file, lineNum, 1}}
 			return
 		}
-		for _, line := range strings.Split(txt, ",") {
-			fragments := strings.Fields(line)
-			if len(fragments) == 0 {
-				continue //ignore empty lines
+		s.scanLine(strings.TrimRight(txt, "\r\n"), Pos{file, lineNum, 1})
+	}
+}
+
+// scanner splits lines of source into fragments, carrying OBTW comments across lines
+type scanner struct {
+	out           chan<- fragment
+	insideComment bool
+}
+
+// scanLine emits the fragments of a single line, which begins at pos
+func (s *scanner) scanLine(line string, pos Pos) {
+	at := func(i int) Pos {
+		return Pos{pos.File, pos.Line, pos.Col + i}
+	}
+	first := true    // the next word is the first of its ","-separated statement
+	emitted := false // fragments have been emitted since the last EOL
+	for i := skipSpace(line, 0); i < len(line); i = skipSpace(line, i) {
+		if line[i] == ',' {
+			if emitted {
+				s.out <- fragment{EOLPhrase, at(i)}
 			}
-			start := 0
-			if insideComment {
-				if fragments[0] != "TLDR" {
-					continue
-				}
-				insideComment = false
-				if len(fragments) == 1 {
-					continue
-				}
-				start = 1
-			} else {
-				if fragments[0] == "OBTW" {
-					insideComment = true
-					continue
-				}
+			first, emitted = true, false
+			i++
+			continue
+		}
+		if s.insideComment {
+			// only a TLDR at the start of a statement ends the comment
+			end := wordEnd(line, i)
+			if first && line[i:end] == "TLDR" {
+				s.insideComment = false
+				first = false
+				i = end
+				continue
 			}
-			for i := start; i < len(fragments); i++ {
-				if fragments[i] == "BTW" {
-					if i > start {
-						out <- EOLPhrase
-					}
-					continue txtLine
-				}
-				out <- fragments[i]
+			i = statementEnd(line, i)
+			continue
+		}
+		if line[i] == '!' {
+			s.out <- fragment{"!", at(i)}
+			first, emitted = false, true
+			i++
+			continue
+		}
+		if line[i] == '"' {
+			end, _ := yarnEnd(line, i)
+			s.out <- fragment{line[i:end], at(i)}
+			first, emitted = false, true
+			i = end
+			continue
+		}
+		end := wordEnd(line, i)
+		switch word := line[i:end]; {
+		case word == "BTW": // comment to the end of the line
+			if emitted {
+				s.out <- fragment{EOLPhrase, at(i)}
 			}
-			out <- EOLPhrase
+			return
+		case first && word == "OBTW":
+			s.insideComment = true
+			i = statementEnd(line, i)
+		case len(word) > 2 && strings.HasSuffix(word, "'Z"): // a slot of the BUKKIT before the 'Z
+			s.out <- fragment{word[:len(word)-2], at(i)}
+			s.out <- fragment{"'Z", at(end - 2)}
+			first, emitted = false, true
+			i = end
+		case len(word) > 1 && strings.HasSuffix(word, "?") && !phraseWords[word]: // CAN HAS a library?
+			s.out <- fragment{word[:len(word)-1], at(i)}
+			s.out <- fragment{"?", at(end - 1)}
+			first, emitted = false, true
+			i = end
+		default:
+			s.out <- fragment{word, at(i)}
+			first, emitted = false, true
+			i = end
 		}
 	}
+	if emitted {
+		s.out <- fragment{EOLPhrase, at(len(line))}
+	}
+}
+
+func skipSpace(line string, i int) int {
+	for i < len(line) {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// wordEnd finds the end of the word starting at i, which stops short of any space, ",", '"' or '!'
+func wordEnd(line string, i int) int {
+	for i < len(line) {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if unicode.IsSpace(r) || r == ',' || r == '"' || r == '!' {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// statementEnd finds the "," separator following i, or the end of the line
+func statementEnd(line string, i int) int {
+	if j := strings.IndexByte(line[i:], ','); j >= 0 {
+		return i + j
+	}
+	return len(line)
+}
+
+// yarnEnd finds the end of the YARN literal opening at i, just past its closing quote.
+// Any character following a ':' is escaped, so :" does not close the literal.
+// An unterminated literal runs to the end of the line and is not closed.
+func yarnEnd(line string, i int) (end int, closed bool) {
+	for i++; i < len(line); i++ {
+		switch line[i] {
+		case ':':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return len(line), false
 }
 
 // EmitTokens parses a Lolcode reader and emits a stream of Tokens
 func EmitTokens(reader *bufio.Reader, out chan<- Token) {
-	frags := make(chan string, 100)
-	go emitFragments(reader, frags)
-	for word := range frags {
+	EmitFileTokens("", reader, out)
+}
+
+// EmitFileTokens is EmitTokens for source read from the named file;
+// the name is recorded in the position of every token.
+func EmitFileTokens(file string, reader *bufio.Reader, out chan<- Token) {
+	frags := make(chan fragment, 100)
+	go emitFragments(file, reader, frags)
+	for frag := range frags {
 		// Emit as many phrase tokens as possible
 		for ok := true; ok; {
-			word, ok = parsePhraseToken(word, frags, out)
+			frag, ok = parsePhraseToken(frag, frags, out)
 		}
+		word, pos := frag.text, frag.pos
 		switch {
 		case word == "": // end of input
+			out <- Token{EOF, EOFPhrase, pos}
 			close(out)
 			return
 		case word == "WIN": // TROOF literal
-			out <- Token{Literal, true}
+			out <- Token{Literal, true, pos}
 		case word == "FAIL":
-			out <- Token{Literal, false}
-		case word == "NOOB": // NOOB is a literal; casting to type NOOB is not allowed
-			out <- Token{Literal, nil}
+			out <- Token{Literal, false, pos}
+		case typeWords[word]: // NOOB is also the value of that type
+			out <- Token{Type, word, pos}
 		case word[0] == '"': // yarn literal
-			out <- yarnLiteralToToken(word)
+			out <- yarnLiteralToToken(word, pos)
 		case isIdentifier(word):
-			out <- Token{Ident, word}
+			out <- Token{Ident, word, pos}
 		default:
 			if numbr, err := strconv.ParseInt(word, 0, 64); err == nil {
-				out <- Token{Literal, numbr}
+				out <- Token{Literal, numbr, pos}
 				continue
 			}
 			if numbar, err := strconv.ParseFloat(word, 64); err == nil {
-				out <- Token{Literal, numbar}
+				out <- Token{Literal, numbar, pos}
 				continue
 			}
-			out <- Token{Err, "Syntax error: unexpected token " + word}
+			out <- Token{Err, "Syntax error: unexpected token " + word, pos}
 		}
 	}
 }
 
 // Reads a phrase starting with the given fragment (word)
 // uses single-word look-ahead to parse as long a phrase as possible
-func parsePhraseToken(word string, frags <-chan string, out chan<- Token) (string, bool) {
+func parsePhraseToken(frag fragment, frags <-chan fragment, out chan<- Token) (fragment, bool) {
 	phraseNode := phraseRoot
+	pos := frag.pos
 	hasRead := false
 	for {
-		nextNode := phraseNode.nodes[word]
+		nextNode := phraseNode.nodes[frag.text]
 		if nextNode == nil {
 			if hasRead {
-				token := Token{phraseNode.t, phraseNode.msg}
+				token := Token{phraseNode.t, phraseNode.msg, pos}
 				if token.Type == Err {
 					// If we have an Error, fill in a parser error as the value
-					token.Value = getErrMessageForPhrase(phraseNode, word)
+					token.Value = getErrMessageForPhrase(phraseNode, frag.text)
 				}
 				out <- token
-				return word, true
+				return frag, true
 			} //else
-			return word, false
+			return frag, false
 		}
 		hasRead = true
 		phraseNode = nextNode
-		word = <-frags
+		frag = <-frags
 	}
 }
 
+// The names of types, which are the Value of Type tokens
+var typeWords = map[string]bool{
+	"NOOB": true, "TROOF": true, "NUMBR": true, "NUMBAR": true, "YARN": true, "BUKKIT": true,
+}
+
 func isIdentifier(s string) bool {
 	if len(s) == 0 || !isLetter(s[0]) {
 		return false
@@ -262,14 +492,22 @@ func isNumberOrUnderscore(l byte) bool {
 	return l >= '0' && l <= '9' || l == '_'
 }
 
-// TODO: add string escaping
-func yarnLiteralToToken(str string) Token {
-	// String literal must end with '"' and have length at least 2
-	if l := len(str); l < 2 || str[l-1] != '"' {
-		return Token{Err, "Invalid string literal: " + str}
+// yarnLiteralToToken decodes a quoted YARN literal.  A YARN with :{var} references
+// becomes an InterpYarn token, to be filled in when it is evaluated.
+func yarnLiteralToToken(str string, pos Pos) Token {
+	// String literal must be closed by its final character
+	if end, closed := yarnEnd(str, 0); !closed || end != len(str) {
+		return Token{Err, "Unterminated YARN literal: " + str, pos}
 	}
 	// chop off the start and end quotes
-	return Token{Literal, str[1 : len(str)-1]}
+	val, errMsg := decodeYarn(str[1 : len(str)-1])
+	switch val.(type) {
+	case string:
+		return Token{Literal, val, pos}
+	case Interpolation:
+		return Token{InterpYarn, val, pos}
+	}
+	return Token{Err, errMsg, pos}
 }
 
 func getErrMessageForPhrase(node *phraseNode, word string) string {
@@ -28,14 +28,14 @@ func TestEmitFragments(t *testing.T) {
 		"tok5", EOLPhrase,
 		"tok6", EOLPhrase,
 		"tok7", EOLPhrase,
-		"KTHXBYE", EOLPhrase}
+		"KTHXBYE", EOLPhrase, ""}
 	reader := bufio.NewReader(strings.NewReader(lolCode))
-	fragments := make(chan string, 100)
-	go emitFragments(reader, fragments)
+	fragments := make(chan fragment, 100)
+	go emitFragments("", reader, fragments)
 	i := 0
 	for fragment := range fragments {
-		if fragment != expected[i] {
-			t.Fatalf("Expected: %s Got: %s", expected[i], fragment)
+		if fragment.text != expected[i] {
+			t.Fatalf("Expected: %s Got: %s", expected[i], fragment.text)
 		}
 		i++
 	}
@@ -56,25 +56,28 @@ KTHXBYE
 
 func TestEmitTokens(t *testing.T) {
 	L := func(i interface{}) Token {
-		return Token{Literal, i}
+		return Token{Type: Literal, Value: i}
 	}
 	I := func(s string) Token {
-		return Token{Ident, s}
+		return Token{Type: Ident, Value: s}
 	}
-	EOL := Token{EOL, EOLPhrase}
+	P := func(t int, s string) Token {
+		return Token{Type: t, Value: s}
+	}
+	EOL := P(EOL, EOLPhrase)
 	expected := []Token{
-		Token{TokHAI, "HAI"}, L(float64(1.2)), EOL,
-		Token{IHASA, "I HAS A"}, I("FISH"), Token{ITZ, "ITZ"}, L(int64(5)), EOL,
-		I("FISH"), Token{R, "R"}, L("foo"), EOL,
-		L(true), EOL, L(false), EOL, L(nil), EOL,
-		Token{KTHXBYE, "KTHXBYE"}, EOL,
+		P(TokHAI, "HAI"), L(float64(1.2)), EOL,
+		P(IHASA, "I HAS A"), I("FISH"), P(ITZ, "ITZ"), L(int64(5)), EOL,
+		I("FISH"), P(R, "R"), L("foo"), EOL,
+		L(true), EOL, L(false), EOL, P(Type, "NOOB"), EOL,
+		P(KTHXBYE, "KTHXBYE"), EOL, P(EOF, EOFPhrase),
 	}
 	reader := bufio.NewReader(strings.NewReader(lolCode2))
 	tokens := make(chan Token, 100)
 	go EmitTokens(reader, tokens)
 	i := 0
 	for token := range tokens {
-		if token != expected[i] {
+		if token.Type != expected[i].Type || token.Value != expected[i].Value {
 			t.Fatalf("Expected: %v Got: %v", expected[i], token)
 		}
 		i++
@@ -84,6 +87,112 @@ func TestEmitTokens(t *testing.T) {
 	}
 }
 
+func TestTypeTokens(t *testing.T) {
+	code := "X IS NOW A YARN, MAEK X A NUMBR, MAEK X NUMBAR, MAEK NOOB TROOF\n"
+	expected := []Token{
+		{Type: Ident, Value: "X"}, {Type: ISNOWA, Value: "IS NOW A"}, {Type: Type, Value: "YARN"}, {Type: EOL},
+		{Type: MAEK, Value: "MAEK"}, {Type: Ident, Value: "X"}, {Type: A, Value: "A"}, {Type: Type, Value: "NUMBR"}, {Type: EOL},
+		{Type: MAEK, Value: "MAEK"}, {Type: Ident, Value: "X"}, {Type: Type, Value: "NUMBAR"}, {Type: EOL},
+		{Type: MAEK, Value: "MAEK"}, {Type: Type, Value: "NOOB"}, {Type: Type, Value: "TROOF"}, {Type: EOL},
+		{Type: EOF},
+	}
+	reader := bufio.NewReader(strings.NewReader(code))
+	tokens := make(chan Token, 100)
+	go EmitTokens(reader, tokens)
+	i := 0
+	for token := range tokens {
+		if token.Type != expected[i].Type || token.Type != EOL && token.Type != EOF && token.Value != expected[i].Value {
+			t.Fatalf("Expected: %s %v Got: %s %v", TypeName(expected[i].Type), expected[i], TypeName(token.Type), token)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), i)
+	}
+}
+
+func TestBukkitTokens(t *testing.T) {
+	code := "I HAS A CAT ITZ LIEK A ANIMAL, CAT HAS A NAME\nCAT'Z SRS \"AGE\" R 3, HOW IZ CAT MEOW, CAT IZ MEOW MKAY\n"
+	expected := []Token{
+		{Type: IHASA, Value: "I HAS A"}, {Type: Ident, Value: "CAT"}, {Type: ITZ, Value: "ITZ"},
+		{Type: LIEKA, Value: "LIEK A"}, {Type: Ident, Value: "ANIMAL"}, {Type: EOL, Value: EOLPhrase},
+		{Type: Ident, Value: "CAT"}, {Type: HASA, Value: "HAS A"}, {Type: Ident, Value: "NAME"}, {Type: EOL, Value: EOLPhrase},
+		{Type: Ident, Value: "CAT", Pos: Pos{"", 2, 1}}, {Type: SLOTZ, Value: "'Z", Pos: Pos{"", 2, 4}},
+		{Type: SRS, Value: "SRS"}, {Type: Literal, Value: "AGE"}, {Type: R, Value: "R"}, {Type: Literal, Value: int64(3)},
+		{Type: EOL, Value: EOLPhrase},
+		{Type: HOWIZ, Value: "HOW IZ"}, {Type: Ident, Value: "CAT"}, {Type: Ident, Value: "MEOW"}, {Type: EOL, Value: EOLPhrase},
+		{Type: Ident, Value: "CAT"}, {Type: IZ, Value: "IZ"}, {Type: Ident, Value: "MEOW"}, {Type: MKAY, Value: "MKAY"},
+		{Type: EOL, Value: EOLPhrase}, {Type: EOF, Value: EOFPhrase},
+	}
+	reader := bufio.NewReader(strings.NewReader(code))
+	tokens := make(chan Token, 100)
+	go EmitTokens(reader, tokens)
+	i := 0
+	for token := range tokens {
+		if token.Type != expected[i].Type || token.Value != expected[i].Value ||
+			expected[i].Pos.Line != 0 && token.Pos != expected[i].Pos {
+			t.Fatalf("Expected: %s %v at %v Got: %s %v at %v", TypeName(expected[i].Type), expected[i],
+				expected[i].Pos, TypeName(token.Type), token, token.Pos)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), i)
+	}
+}
+
+func TestCanHasTokens(t *testing.T) {
+	code := "CAN HAS STDIO?\nCAN HAS MATH ?, O RLY?, WTF?\n"
+	expected := []Token{
+		{Type: CANHAS, Value: "CAN HAS"}, {Type: Ident, Value: "STDIO", Pos: Pos{"", 1, 9}},
+		{Type: QUESTION, Value: "?", Pos: Pos{"", 1, 14}}, {Type: EOL, Value: EOLPhrase},
+		{Type: CANHAS, Value: "CAN HAS"}, {Type: Ident, Value: "MATH"}, {Type: QUESTION, Value: "?", Pos: Pos{"", 2, 14}},
+		{Type: EOL, Value: EOLPhrase}, {Type: ORLY, Value: "O RLY?"}, {Type: EOL, Value: EOLPhrase},
+		{Type: WTF, Value: "WTF?"}, {Type: EOL, Value: EOLPhrase}, {Type: EOF, Value: EOFPhrase},
+	}
+	reader := bufio.NewReader(strings.NewReader(code))
+	tokens := make(chan Token, 100)
+	go EmitTokens(reader, tokens)
+	i := 0
+	for token := range tokens {
+		if token.Type != expected[i].Type || token.Value != expected[i].Value ||
+			expected[i].Pos.Line != 0 && token.Pos != expected[i].Pos {
+			t.Fatalf("Expected: %s %v at %v Got: %s %v at %v", TypeName(expected[i].Type), expected[i],
+				expected[i].Pos, TypeName(token.Type), token, token.Pos)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), i)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	code := "HAI 1.2\n  I HAS A FISH ITZ 5, FISH\nKTHXBYE"
+	expected := []Pos{
+		{"fish.lol", 1, 1}, {"fish.lol", 1, 5}, {"fish.lol", 1, 8},
+		{"fish.lol", 2, 3}, {"fish.lol", 2, 11}, {"fish.lol", 2, 16}, {"fish.lol", 2, 20}, {"fish.lol", 2, 21},
+		{"fish.lol", 2, 23}, {"fish.lol", 2, 27},
+		{"fish.lol", 3, 1}, {"fish.lol", 3, 8}, {"fish.lol", 4, 1},
+	}
+	reader := bufio.NewReader(strings.NewReader(code))
+	tokens := make(chan Token, 100)
+	go EmitFileTokens("fish.lol", reader, tokens)
+	i := 0
+	for token := range tokens {
+		if token.Pos != expected[i] {
+			t.Fatalf("Token %v: expected position %v, got %v", token, expected[i], token.Pos)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), i)
+	}
+	if s := expected[4].String(); s != "fish.lol:2:11" {
+		t.Fatalf("Position formatted as %s", s)
+	}
+}
+
 func TestIsIdentifier(t *testing.T) {
 	identifiers := []string{
 		"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_",
@@ -105,3 +214,87 @@ func TestIsIdentifier(t *testing.T) {
 		}
 	}
 }
+
+func TestYarnFragments(t *testing.T) {
+	code := `VISIBLE "HELLO WORLD", "a, b"  "BTW"x BTW "comment
+"OBTW", "say :"hi:"" "::"
+"unterminated, :"
+`
+	expected := []string{
+		"VISIBLE", `"HELLO WORLD"`, EOLPhrase, `"a, b"`, `"BTW"`, "x", EOLPhrase,
+		`"OBTW"`, EOLPhrase, `"say :"hi:""`, `"::"`, EOLPhrase,
+		`"unterminated, :"`, EOLPhrase, "",
+	}
+	reader := bufio.NewReader(strings.NewReader(code))
+	fragments := make(chan fragment, 100)
+	go emitFragments("", reader, fragments)
+	i := 0
+	for fragment := range fragments {
+		if fragment.text != expected[i] {
+			t.Fatalf("Expected: %s Got: %s", expected[i], fragment.text)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("Expected %d fragments, got %d", len(expected), i)
+	}
+}
+
+func TestYarnLiterals(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader(`"HELLO WORLD" "a, b" "BTW" "oops`))
+	tokens := make(chan Token, 100)
+	go EmitTokens(reader, tokens)
+	for _, expected := range []string{"HELLO WORLD", "a, b", "BTW"} {
+		if token := <-tokens; token.Type != Literal || token.Value != expected {
+			t.Fatalf("Expected literal %q, got %v", expected, token)
+		}
+	}
+	if token := <-tokens; token.Type != Err {
+		t.Fatalf("Expected error for unterminated literal, got %v", token)
+	}
+}
+
+func TestYarnEscapes(t *testing.T) {
+	type testCase struct {
+		code     string
+		expected interface{}
+	}
+	testCases := []testCase{
+		{`"a:)b"`, "a\nb"},
+		{`":>:o"`, "\t\a"},
+		{`"say :"hi:" ::)"`, `say "hi" :)`},
+		{`":(263A) :(1F600)"`, "☺ \U0001f600"},
+		{`":[SNOWMAN]:[latin small letter a]:[CJK UNIFIED IDEOGRAPH-4E00]"`, "☃a一"},
+		{`":[CJK RADICAL REPEAT]:[CJK COMPATIBILITY IDEOGRAPH-F900]:[CJK UNIFIED IDEOGRAPH-20000]"`, "\u2e80\uf900\U00020000"},
+		{`":{FISH}"`, Interpolation{{"FISH", true}}},
+		{`"I HAS :{N} FISH:)"`, Interpolation{{"I HAS ", false}, {"N", true}, {" FISH\n", false}}},
+	}
+	for _, tc := range testCases {
+		tok := yarnLiteralToToken(tc.code, Pos{})
+		switch expected := tc.expected.(type) {
+		case string:
+			if tok.Type != Literal || tok.Value != expected {
+				t.Fatalf("%s: expected %q, got %v", tc.code, expected, tok)
+			}
+		case Interpolation:
+			got, ok := tok.Value.(Interpolation)
+			if tok.Type != InterpYarn || !ok || len(got) != len(expected) {
+				t.Fatalf("%s: expected %v, got %v", tc.code, expected, tok)
+			}
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Fatalf("%s: expected %v, got %v", tc.code, expected, got)
+				}
+			}
+		}
+	}
+	for _, bad := range []string{`":x"`, `":(110000)"`, `":(zz)"`, `":[NOT A NAME]"`, `":{4EVER}"`, `":{FISH"`,
+		`":[CJK UNIFIED IDEOGRAPH-2E80]"`, `":[CJK UNIFIED IDEOGRAPH-F900]"`, `":[CJK COMPATIBILITY IDEOGRAPH-4E00]"`} {
+		if tok := yarnLiteralToToken(bad, Pos{}); tok.Type != Err {
+			t.Fatalf("%s: expected error, got %v", bad, tok)
+		}
+	}
+	if tok := yarnLiteralToToken(`":☃"`, Pos{}); tok.Value != "Invalid escape sequence in YARN: :☃" {
+		t.Fatalf("Expected the escape to be named whole, got %v", tok.Value)
+	}
+}
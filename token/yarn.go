@@ -0,0 +1,162 @@
+package token
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed" // for the rune name table
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Interpolation is the Value of an InterpYarn token: the pieces of a YARN literal
+// containing :{var} references, in order.
+type Interpolation []YarnPiece
+
+// YarnPiece is a run of literal text, or the name of a variable whose value is spliced in.
+type YarnPiece struct {
+	Text  string
+	IsVar bool
+}
+
+// decodeYarn replaces the escape sequences in the body of a YARN literal.
+// If the body references variables, the returned value is an Interpolation rather than a string.
+func decodeYarn(body string) (interface{}, string) {
+	var pieces Interpolation
+	var text strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != ':' {
+			text.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(body) {
+			return nil, "Unfinished escape sequence at end of YARN"
+		}
+		switch c = body[i]; c {
+		case ')':
+			text.WriteByte('\n')
+		case '>':
+			text.WriteByte('\t')
+		case 'o':
+			text.WriteByte('\a')
+		case '"', ':':
+			text.WriteByte(c)
+		case '(', '[', '{':
+			end := strings.IndexByte(body[i:], closers[c])
+			if end < 0 {
+				return nil, "Unclosed :" + body[i:i+1] + " escape in YARN"
+			}
+			arg := body[i+1 : i+end]
+			i += end
+			switch c {
+			case '(':
+				r, err := strconv.ParseUint(arg, 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return nil, "Invalid code point in YARN escape: :(" + arg + ")"
+				}
+				text.WriteRune(rune(r))
+			case '[':
+				r, ok := lookupRuneName(arg)
+				if !ok {
+					return nil, "Unknown Unicode name in YARN escape: :[" + arg + "]"
+				}
+				text.WriteRune(r)
+			case '{':
+				if !isIdentifier(arg) {
+					return nil, "Invalid variable in YARN interpolation: :{" + arg + "}"
+				}
+				if text.Len() > 0 {
+					pieces = append(pieces, YarnPiece{text.String(), false})
+					text.Reset()
+				}
+				pieces = append(pieces, YarnPiece{arg, true})
+			}
+		default:
+			r, _ := utf8.DecodeRuneInString(body[i:])
+			return nil, "Invalid escape sequence in YARN: :" + string(r)
+		}
+	}
+	if pieces == nil {
+		return text.String(), ""
+	}
+	if text.Len() > 0 {
+		pieces = append(pieces, YarnPiece{text.String(), false})
+	}
+	return pieces, ""
+}
+
+var closers = map[byte]byte{'(': ')', '[': ']', '{': '}'}
+
+//go:generate go run gen_runenames.go UnicodeData.txt
+//go:embed runenames.gz
+var runeNamesGz []byte
+
+var (
+	runeNamesOnce sync.Once
+	runeNames     map[string]rune
+)
+
+// Ideographs are named for their code points rather than listed in runenames.gz.
+// Each name covers the characters of its script in the blocks given, which
+// for Han leaves out the radicals and the like, named in the usual way.
+var algorithmicNames = []struct {
+	prefix         string
+	script, blocks *unicode.RangeTable
+}{
+	{"CJK UNIFIED IDEOGRAPH-", unicode.Han, cjkUnified},
+	{"CJK COMPATIBILITY IDEOGRAPH-", unicode.Han, cjkCompatibility},
+	{"TANGUT IDEOGRAPH-", unicode.Tangut, unicode.Tangut},
+	{"KHITAN SMALL SCRIPT CHARACTER-", unicode.Khitan_Small_Script, unicode.Khitan_Small_Script},
+	{"NUSHU CHARACTER-", unicode.Nushu, unicode.Nushu},
+}
+
+// cjkUnified is the CJK Unified Ideographs block and its extensions
+var cjkUnified = &unicode.RangeTable{
+	R16: []unicode.Range16{{Lo: 0x3400, Hi: 0x4dbf, Stride: 1}, {Lo: 0x4e00, Hi: 0x9fff, Stride: 1}},
+	R32: []unicode.Range32{
+		{Lo: 0x20000, Hi: 0x2a6df, Stride: 1}, {Lo: 0x2a700, Hi: 0x2b73f, Stride: 1},
+		{Lo: 0x2b740, Hi: 0x2b81f, Stride: 1}, {Lo: 0x2b820, Hi: 0x2ceaf, Stride: 1},
+		{Lo: 0x2ceb0, Hi: 0x2ebef, Stride: 1}, {Lo: 0x2ebf0, Hi: 0x2ee5f, Stride: 1},
+		{Lo: 0x30000, Hi: 0x3134f, Stride: 1}, {Lo: 0x31350, Hi: 0x323af, Stride: 1},
+	},
+}
+
+// cjkCompatibility is the CJK Compatibility Ideographs block and its supplement
+var cjkCompatibility = &unicode.RangeTable{
+	R16: []unicode.Range16{{Lo: 0xf900, Hi: 0xfaff, Stride: 1}},
+	R32: []unicode.Range32{{Lo: 0x2f800, Hi: 0x2fa1f, Stride: 1}},
+}
+
+// lookupRuneName finds the character with the given Unicode normative name, ignoring case
+func lookupRuneName(name string) (rune, bool) {
+	name = strings.ToUpper(name)
+	for _, a := range algorithmicNames {
+		if strings.HasPrefix(name, a.prefix) {
+			r, err := strconv.ParseUint(name[len(a.prefix):], 16, 32)
+			return rune(r), err == nil && unicode.Is(a.blocks, rune(r)) && unicode.Is(a.script, rune(r))
+		}
+	}
+	runeNamesOnce.Do(loadRuneNames)
+	r, ok := runeNames[name]
+	return r, ok
+}
+
+func loadRuneNames() {
+	runeNames = make(map[string]rune)
+	zr, err := gzip.NewReader(bytes.NewReader(runeNamesGz))
+	if err != nil {
+		panic("corrupt rune name table: " + err.Error())
+	}
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		sep := strings.IndexByte(line, ';')
+		r, _ := strconv.ParseUint(line[:sep], 16, 32)
+		runeNames[line[sep+1:]] = rune(r)
+	}
+}
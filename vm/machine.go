@@ -0,0 +1,369 @@
+package vm
+
+import "lol/token"
+
+// Host gives a Machine what it can't do by itself: the Lolcode semantics of values
+// it has no fast path for, input and output, function calls, and errors.
+// The Host stops the program by panicking; the Machine doesn't recover.
+type Host interface {
+	Pos(pos token.Pos) // a statement starts
+	Step()             // a loop goes round again
+
+	Binary(op Op, x, y Value) Value // math that isn't on two numbers, or fails
+	Saem(x, y Value) bool           // comparison of Other values
+	Troof(x Value) bool             // TROOF cast of an Other value
+	Cast(x Value, typ string) Value
+	Smoosh(vals []Value) Value
+
+	Visible(vals []Value, newline bool)
+	Gimmeh() Value
+	CanHas(lib Value, declare func(name string, val Value)) // lib is whatever the compiler put in Consts
+
+	Define(f *Func)
+	Call(name string, args []Value) Value
+	Name(x Value) string // the YARN a name given by SRS is cast to
+
+	Slot(obj, name Value) Value
+	SetSlot(obj, name, val Value, declare bool)
+	Method(obj, name Value, args []Value) Value
+	Liek(parent Value) Value
+	Func(f *Func) Value // a function to put in a slot
+
+	Undefined(name string, assign bool) // a variable is used without being declared
+	Misplaced(op Op)                    // FOUND YR or GTFO has nowhere to go
+}
+
+// Machine runs compiled code
+type Machine struct {
+	host  Host
+	stack []Value // of every function being run, each above its caller's
+}
+
+// New makes a Machine that runs code with the help of host
+func New(host Host) *Machine {
+	return &Machine{host: host}
+}
+
+// A scope is one scope of variables; a frame has a stack of them, outermost first.
+// Scopes hold few variables, so a slice is quicker to make and search than a map.
+type scope []binding
+
+type binding struct {
+	name string
+	val  Value
+}
+
+// Run runs the top level of a program.  globals is the outermost scope of its frame;
+// it's updated with the variables declared and assigned, even if the host stops the program.
+func (m *Machine) Run(f *Func, globals map[string]Value) {
+	vars := make(scope, 0, len(globals))
+	for name, val := range globals {
+		vars = append(vars, binding{name, val})
+	}
+	scopes := []scope{vars}
+	defer func() {
+		for _, b := range scopes[0] {
+			globals[b.name] = b.val
+		}
+	}()
+	m.exec(f, scopes, true)
+}
+
+// Call runs a function in a frame of its own, which holds only its arguments and IT,
+// and returns the function's value.  args may be on the machine's stack.
+func (m *Machine) Call(f *Func, args []Value) Value {
+	vars := make(scope, len(f.Params)+1, len(f.Params)+4)
+	vars[0] = binding{"IT", Value{}}
+	for i, p := range f.Params {
+		vars[i+1] = binding{p, args[i]}
+	}
+	return m.exec(f, []scope{vars}, false)
+}
+
+// exec runs code with its operands on the stack above base, and leaves the stack as it found it
+func (m *Machine) exec(f *Func, scopes []scope, top bool) Value {
+	base := len(m.stack)
+	defer func() {
+		m.stack = m.stack[:base]
+	}()
+	code := f.Code
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.Op {
+		case OpConst:
+			m.push(f.Consts[in.A])
+		case OpLoad:
+			name := f.Names[in.A]
+			v, ok := lookup(scopes, name)
+			if !ok {
+				m.host.Undefined(name, false)
+			}
+			m.push(v)
+		case OpStore:
+			name := f.Names[in.A]
+			if !assign(scopes, name, m.pop()) {
+				m.host.Undefined(name, true)
+			}
+		case OpDeclare:
+			declare(&scopes[len(scopes)-1], f.Names[in.A], m.pop())
+		case OpLoadSrs:
+			name := m.host.Name(m.stack[len(m.stack)-1])
+			v, ok := lookup(scopes, name)
+			if !ok {
+				m.host.Undefined(name, false)
+			}
+			m.stack[len(m.stack)-1] = v
+		case OpStoreSrs:
+			v := m.pop()
+			name := m.host.Name(m.pop())
+			if !assign(scopes, name, v) {
+				m.host.Undefined(name, true)
+			}
+		case OpDeclareSrs:
+			v := m.pop()
+			declare(&scopes[len(scopes)-1], m.host.Name(m.pop()), v)
+		case OpPop:
+			m.pop()
+		case OpDup:
+			m.stack = append(m.stack, m.stack[len(m.stack)-int(in.A):]...)
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpMax, OpMin:
+			y := m.pop()
+			m.stack[len(m.stack)-1] = m.arith(in.Op, m.stack[len(m.stack)-1], y)
+		case OpSaem, OpDiffrint:
+			y := m.pop()
+			m.stack[len(m.stack)-1] = troofValue(m.saem(m.stack[len(m.stack)-1], y) == (in.Op == OpSaem))
+		case OpXor:
+			y := m.pop()
+			m.stack[len(m.stack)-1] = troofValue(m.troof(m.stack[len(m.stack)-1]) != m.troof(y))
+		case OpNot:
+			m.stack[len(m.stack)-1] = troofValue(!m.troof(m.stack[len(m.stack)-1]))
+		case OpTroof:
+			m.stack[len(m.stack)-1] = troofValue(m.troof(m.stack[len(m.stack)-1]))
+		case OpCast:
+			m.stack[len(m.stack)-1] = m.host.Cast(m.stack[len(m.stack)-1], f.Names[in.A])
+		case OpSmoosh:
+			n := len(m.stack) - int(in.A)
+			v := m.host.Smoosh(m.stack[n:])
+			m.stack = append(m.stack[:n], v)
+		case OpCall:
+			n := len(m.stack) - int(in.B)
+			v := m.host.Call(f.Names[in.A], m.stack[n:])
+			m.stack = append(m.stack[:n], v)
+		case OpCallSrs:
+			n := len(m.stack) - int(in.A)
+			v := m.host.Call(m.host.Name(m.stack[n-1]), m.stack[n:])
+			m.stack = append(m.stack[:n-1], v)
+		case OpSlot:
+			name := m.pop()
+			m.stack[len(m.stack)-1] = m.host.Slot(m.stack[len(m.stack)-1], name)
+		case OpSetSlot:
+			val, name, obj := m.pop(), m.pop(), m.pop()
+			m.host.SetSlot(obj, name, val, in.A == 1)
+		case OpMethod:
+			n := len(m.stack) - int(in.A)
+			v := m.host.Method(m.stack[n-2], m.stack[n-1], m.stack[n:])
+			m.stack = append(m.stack[:n-2], v)
+		case OpLiek:
+			m.stack[len(m.stack)-1] = m.host.Liek(m.stack[len(m.stack)-1])
+		case OpFunc:
+			m.push(m.host.Func(f.Funcs[in.A]))
+		case OpVisible:
+			n := len(m.stack) - int(in.A)
+			m.host.Visible(m.stack[n:], in.B == 1)
+			m.stack = m.stack[:n]
+		case OpGimmeh:
+			m.push(m.host.Gimmeh())
+		case OpCanHas:
+			s := &scopes[len(scopes)-1]
+			m.host.CanHas(f.Consts[in.A], func(name string, val Value) {
+				declare(s, name, val)
+			})
+		case OpJump:
+			pc += int(in.A)
+		case OpJumpIfFalse:
+			if !m.troof(m.pop()) {
+				pc += int(in.A)
+			}
+		case OpAndJump:
+			if !m.troof(m.pop()) {
+				m.push(troofValue(false))
+				pc += int(in.A)
+			}
+		case OpOrJump:
+			if m.troof(m.pop()) {
+				m.push(troofValue(true))
+				pc += int(in.A)
+			}
+		case OpCase:
+			if m.saem(m.stack[len(m.stack)-1], f.Consts[in.A]) {
+				m.pop()
+				pc += int(in.B)
+			}
+		case OpEnterScope:
+			scopes = append(scopes, nil)
+		case OpExitScope:
+			scopes = scopes[:len(scopes)-1]
+		case OpPos:
+			m.host.Pos(f.Pos[in.A])
+		case OpStep:
+			m.host.Step()
+		case OpDefine:
+			m.host.Define(f.Funcs[in.A])
+		case OpReturn:
+			if top {
+				m.host.Misplaced(OpReturn)
+			}
+			if in.A == 1 {
+				return Value{}
+			}
+			return m.pop()
+		case OpGTFO:
+			m.host.Misplaced(OpGTFO)
+		}
+	}
+	return Value{}
+}
+
+func (m *Machine) push(v Value) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *Machine) pop() Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+func lookup(scopes []scope, name string) (Value, bool) {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		for _, b := range scopes[i] {
+			if b.name == name {
+				return b.val, true
+			}
+		}
+	}
+	return Value{}, false
+}
+
+func assign(scopes []scope, name string, v Value) bool {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		s := scopes[i]
+		for j := range s {
+			if s[j].name == name {
+				s[j].val = v
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declare creates a variable in s, or changes its value if s already has it
+func declare(s *scope, name string, v Value) {
+	for j := range *s {
+		if (*s)[j].name == name {
+			(*s)[j].val = v
+			return
+		}
+	}
+	*s = append(*s, binding{name, v})
+}
+
+// arith does math on two numbers, and leaves everything else to the host:
+// casts, errors, and MOD OF on NUMBARs
+func (m *Machine) arith(op Op, x, y Value) Value {
+	switch {
+	case x.kind == Numbr && y.kind == Numbr:
+		a, b := x.n, y.n
+		switch op {
+		case OpAdd:
+			return Value{kind: Numbr, n: a + b}
+		case OpSub:
+			return Value{kind: Numbr, n: a - b}
+		case OpMul:
+			return Value{kind: Numbr, n: a * b}
+		case OpDiv:
+			if b != 0 {
+				return Value{kind: Numbr, n: a / b}
+			}
+		case OpMod:
+			if b != 0 {
+				return Value{kind: Numbr, n: a % b}
+			}
+		case OpMax:
+			if a < b {
+				return y
+			}
+			return x
+		case OpMin:
+			if a > b {
+				return y
+			}
+			return x
+		}
+	case isNumber(x) && isNumber(y) && op != OpMod:
+		a, b := x.float(), y.float()
+		switch op {
+		case OpAdd:
+			return numbarValue(a + b)
+		case OpSub:
+			return numbarValue(a - b)
+		case OpMul:
+			return numbarValue(a * b)
+		case OpDiv:
+			if b != 0 {
+				return numbarValue(a / b)
+			}
+		case OpMax:
+			if a < b {
+				return numbarValue(b)
+			}
+			return numbarValue(a)
+		case OpMin:
+			if a > b {
+				return numbarValue(b)
+			}
+			return numbarValue(a)
+		}
+	}
+	return m.host.Binary(op, x, y)
+}
+
+func isNumber(v Value) bool {
+	return v.kind == Numbr || v.kind == Numbar
+}
+
+// saem compares values without casting, except that NUMBRs and NUMBARs compare as numbers
+func (m *Machine) saem(x, y Value) bool {
+	switch {
+	case x.kind == Other || y.kind == Other:
+		return m.host.Saem(x, y)
+	case x.kind == Numbr && y.kind == Numbar || x.kind == Numbar && y.kind == Numbr:
+		return x.float() == y.float()
+	case x.kind != y.kind:
+		return false
+	}
+	switch x.kind {
+	case Troof, Numbr:
+		return x.n == y.n
+	case Numbar:
+		return x.float() == y.float()
+	case Yarn:
+		return x.x == y.x
+	}
+	return true // NOOB
+}
+
+func (m *Machine) troof(v Value) bool {
+	switch v.kind {
+	case Noob:
+		return false
+	case Troof, Numbr:
+		return v.n != 0
+	case Numbar:
+		return v.float() != 0
+	case Yarn:
+		return v.x != ""
+	}
+	return m.host.Troof(v)
+}
@@ -0,0 +1,134 @@
+package vm
+
+import (
+	"fmt"
+	"lol/token"
+	"strings"
+)
+
+// Op is the operation of an instruction.  Operations take their operands from the
+// stack and push their result, unless noted.
+type Op uint8
+
+// Operations.  The A and B of an instruction are its operands; jump offsets count
+// from the next instruction.
+const (
+	OpConst       Op = iota // push Consts[A]
+	OpLoad                  // push the variable Names[A]
+	OpStore                 // pop into the variable Names[A]
+	OpDeclare               // pop into a new variable Names[A] in the innermost scope
+	OpLoadSrs               // pop a name given by SRS, and push the variable it names
+	OpStoreSrs              // pop a value and then a name given by SRS, and assign the variable it names
+	OpDeclareSrs            // pop a value and then a name given by SRS, and declare the variable it names
+	OpPop                   // discard the top of the stack
+	OpDup                   // push copies of the top A values
+	OpAdd                   // SUM OF
+	OpSub                   // DIFF OF
+	OpMul                   // PRODUKT OF
+	OpDiv                   // QUOSHUNT OF
+	OpMod                   // MOD OF
+	OpMax                   // BIGGR OF
+	OpMin                   // SMALLR OF
+	OpSaem                  // BOTH SAEM
+	OpDiffrint              // DIFFRINT
+	OpXor                   // WON OF
+	OpNot                   // NOT
+	OpTroof                 // cast to TROOF
+	OpCast                  // cast to the type Names[A]
+	OpSmoosh                // join the top A values into a YARN
+	OpCall                  // call the function Names[A] with the top B values
+	OpCallSrs               // call the function named by SRS below the top A values, with them
+	OpSlot                  // pop a slot name, and push that slot of the BUKKIT below it
+	OpSetSlot               // pop a value, a slot name and a BUKKIT, and assign the slot; declare it if A is 1
+	OpMethod                // call the function in a slot with the top A values, on the BUKKIT below its name
+	OpLiek                  // replace a BUKKIT with a new one that inherits from it
+	OpFunc                  // push Funcs[A], to be put in a slot
+	OpVisible               // print the top A values, then a newline if B is 1
+	OpGimmeh                // push a line of input
+	OpCanHas                // load the library Consts[A], and declare its variables in the innermost scope
+	OpJump                  // jump A
+	OpJumpIfFalse           // pop, and jump A if it's FAIL
+	OpAndJump               // pop, and if it's FAIL, push FAIL and jump A
+	OpOrJump                // pop, and if it's WIN, push WIN and jump A
+	OpCase                  // if the top is the same as Consts[A], pop it and jump B
+	OpEnterScope            // start a scope nested in the current one
+	OpExitScope             // end the innermost scope
+	OpPos                   // start the statement at Pos[A]
+	OpStep                  // go round a loop again
+	OpDefine                // define the function Funcs[A]
+	OpReturn                // return from the function with the top value, or NOOB if A is 1
+	OpGTFO                  // a GTFO with nothing to break out of
+)
+
+var opNames = [...]string{
+	"CONST", "LOAD", "STORE", "DECLARE", "LOADSRS", "STORESRS", "DECLARESRS", "POP", "DUP",
+	"ADD", "SUB", "MUL", "DIV", "MOD", "MAX", "MIN",
+	"SAEM", "DIFFRINT", "XOR", "NOT", "TROOF", "CAST", "SMOOSH",
+	"CALL", "CALLSRS", "SLOT", "SETSLOT", "METHOD", "LIEK", "FUNC", "VISIBLE", "GIMMEH", "CANHAS",
+	"JUMP", "JUMPIFFALSE", "ANDJUMP", "ORJUMP", "CASE",
+	"ENTERSCOPE", "EXITSCOPE", "POS", "STEP", "DEFINE", "RETURN", "GTFO",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("Op(%d)", op)
+}
+
+// Instr is a single instruction
+type Instr struct {
+	Op   Op
+	A, B int32
+}
+
+// Func is compiled code: the body of a function, or the top level of a program.
+// Its instructions refer to constants, names, positions and functions by their
+// index in its tables.
+type Func struct {
+	Name   string   // "" for the top level
+	Params []string // names of the arguments
+	Code   []Instr
+	Consts []Value
+	Names  []string // of variables, functions and types
+	Pos    []token.Pos
+	Funcs  []*Func // defined by HOW IZ I or HOW IZ inside this code
+}
+
+// String disassembles the code, one instruction per line, followed by the functions it defines
+func (f *Func) String() string {
+	var out strings.Builder
+	name := f.Name
+	if name == "" {
+		name = "HAI"
+	}
+	fmt.Fprintf(&out, "%s:\n", strings.Join(append([]string{name}, f.Params...), " "))
+	for pc, in := range f.Code {
+		line := fmt.Sprintf("%4d %-11s", pc, in.Op)
+		switch in.Op {
+		case OpConst, OpCanHas:
+			line += fmt.Sprintf(" %v", f.Consts[in.A])
+		case OpLoad, OpStore, OpDeclare, OpCast:
+			line += " " + f.Names[in.A]
+		case OpCall:
+			line += fmt.Sprintf(" %s %d", f.Names[in.A], in.B)
+		case OpDup, OpSetSlot, OpMethod, OpCallSrs:
+			line += fmt.Sprintf(" %d", in.A)
+		case OpSmoosh, OpVisible, OpReturn:
+			line += fmt.Sprintf(" %d %d", in.A, in.B)
+		case OpJump, OpJumpIfFalse, OpAndJump, OpOrJump:
+			line += fmt.Sprintf(" -> %d", pc+1+int(in.A))
+		case OpCase:
+			line += fmt.Sprintf(" %v -> %d", f.Consts[in.A], pc+1+int(in.B))
+		case OpPos:
+			line += fmt.Sprintf(" %v", f.Pos[in.A])
+		case OpDefine, OpFunc:
+			line += " " + f.Funcs[in.A].Name
+		}
+		out.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	for _, fn := range f.Funcs {
+		out.WriteString(fn.String())
+	}
+	return out.String()
+}
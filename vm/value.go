@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+)
+
+// Kind is the Lolcode type of a Value
+type Kind uint8
+
+// Kinds of Value
+const (
+	Noob Kind = iota
+	Troof
+	Numbr
+	Numbar
+	Yarn
+	Other // a value the machine leaves to its Host
+)
+
+// Value is a Lolcode value.  Unlike the interface{} values of the lang package,
+// it holds TROOFs and numbers without allocating.
+type Value struct {
+	kind Kind
+	n    int64       // a NUMBR, the bits of a NUMBAR, or 1 for WIN
+	x    interface{} // the string of a YARN, or an Other value
+}
+
+// ValueOf converts a value as the lang package holds it: nil, bool, int64, float64
+// or string.  Anything else is of kind Other.
+func ValueOf(x interface{}) Value {
+	switch x := x.(type) {
+	case nil:
+		return Value{}
+	case bool:
+		return troofValue(x)
+	case int64:
+		return Value{kind: Numbr, n: x}
+	case float64:
+		return numbarValue(x)
+	case string:
+		return Value{kind: Yarn, x: x}
+	default:
+		return Value{kind: Other, x: x}
+	}
+}
+
+func numbarValue(f float64) Value {
+	return Value{kind: Numbar, n: int64(math.Float64bits(f))}
+}
+
+func troofValue(b bool) Value {
+	if b {
+		return Value{kind: Troof, n: 1}
+	}
+	return Value{kind: Troof}
+}
+
+// Kind tells the type of the value
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// Interface converts the value back to the form ValueOf takes
+func (v Value) Interface() interface{} {
+	switch v.kind {
+	case Noob:
+		return nil
+	case Troof:
+		return v.n != 0
+	case Numbr:
+		return v.n
+	case Numbar:
+		return v.float()
+	default:
+		return v.x
+	}
+}
+
+func (v Value) String() string {
+	switch v.kind {
+	case Noob:
+		return "NOOB"
+	case Troof:
+		if v.n != 0 {
+			return "WIN"
+		}
+		return "FAIL"
+	case Yarn:
+		return fmt.Sprintf("%q", v.x)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// float is the value of a NUMBR or NUMBAR as a float64
+func (v Value) float() float64 {
+	if v.kind == Numbr {
+		return float64(v.n)
+	}
+	return math.Float64frombits(uint64(v.n))
+}
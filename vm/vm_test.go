@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"fmt"
+	"lol/token"
+	"strings"
+	"testing"
+)
+
+// testHost prints with Visible and records whatever else the machine asks of it
+type testHost struct {
+	out   strings.Builder
+	asked []string
+}
+
+func (h *testHost) Pos(pos token.Pos) {}
+func (h *testHost) Step()             {}
+func (h *testHost) Binary(op Op, x, y Value) Value {
+	h.asked = append(h.asked, fmt.Sprintf("%v %v %v", op, x, y))
+	return Value{}
+}
+func (h *testHost) Saem(x, y Value) bool           { return false }
+func (h *testHost) Troof(x Value) bool             { return false }
+func (h *testHost) Cast(x Value, typ string) Value { return x }
+func (h *testHost) Smoosh(vals []Value) Value      { return Value{} }
+func (h *testHost) Gimmeh() Value                  { return Value{} }
+func (h *testHost) Define(f *Func)                 {}
+func (h *testHost) CanHas(lib Value, declare func(name string, val Value)) {
+	declare(lib.String(), ValueOf(true))
+}
+func (h *testHost) Call(name string, args []Value) Value {
+	return Value{}
+}
+func (h *testHost) Name(x Value) string                        { return x.String() }
+func (h *testHost) Slot(obj, name Value) Value                 { return Value{} }
+func (h *testHost) SetSlot(obj, name, val Value, declare bool) {}
+func (h *testHost) Method(obj, name Value, args []Value) Value {
+	return Value{}
+}
+func (h *testHost) Liek(parent Value) Value { return Value{} }
+func (h *testHost) Func(f *Func) Value      { return Value{} }
+func (h *testHost) Undefined(name string, assign bool) {
+	panic("undefined " + name)
+}
+func (h *testHost) Misplaced(op Op) {
+	panic("misplaced " + op.String())
+}
+func (h *testHost) Visible(vals []Value, newline bool) {
+	for _, v := range vals {
+		h.out.WriteString(v.String() + " ")
+	}
+	if newline {
+		h.out.WriteByte('\n')
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	for _, x := range []interface{}{nil, true, false, int64(-3), 2.5, "", "CAT"} {
+		if v := ValueOf(x); v.Interface() != x {
+			t.Errorf("%#v came back as %#v", x, v.Interface())
+		}
+	}
+	if k := ValueOf(int64(0)).Kind(); k != Numbr {
+		t.Errorf("0 is of kind %v, expected %v", k, Numbr)
+	}
+	if k := ValueOf([]int{}).Kind(); k != Other {
+		t.Errorf("A slice is of kind %v, expected %v", k, Other)
+	}
+}
+
+func TestMachine(t *testing.T) {
+	// X is 1, then X is X PLUS 2.5, printed unless it's 3.5; then "A" plus X goes to the host
+	f := &Func{
+		Code: []Instr{
+			{OpConst, 0, 0},
+			{OpDeclare, 0, 0},
+			{OpLoad, 0, 0},
+			{OpConst, 1, 0},
+			{OpAdd, 0, 0},
+			{OpStore, 0, 0},
+			{OpLoad, 0, 0},
+			{OpCase, 2, 2},
+			{OpVisible, 1, 1},
+			{OpJump, 1, 0},
+			{OpStep, 0, 0},
+			{OpConst, 3, 0},
+			{OpLoad, 0, 0},
+			{OpAdd, 0, 0},
+			{OpLoad, 1, 0},
+			{OpVisible, 2, 1},
+		},
+		Consts: []Value{ValueOf(int64(1)), ValueOf(2.5), ValueOf(3.5), ValueOf("A")},
+		Names:  []string{"X", "IT"},
+	}
+	host := &testHost{}
+	globals := map[string]Value{"IT": ValueOf(true)}
+	New(host).Run(f, globals)
+	if out := host.out.String(); out != "NOOB WIN \n" {
+		t.Errorf("Printed %q", out)
+	}
+	if len(host.asked) != 1 || host.asked[0] != `ADD "A" 3.5` {
+		t.Errorf("Asked the host %q", host.asked)
+	}
+	if x := globals["X"].Interface(); x != 3.5 {
+		t.Errorf("X is %#v, expected 3.5", x)
+	}
+
+	expected := `HAI:
+   0 CONST       1
+   1 DECLARE     X
+   2 LOAD        X
+   3 CONST       2.5
+   4 ADD
+   5 STORE       X
+   6 LOAD        X
+   7 CASE        3.5 -> 10
+   8 VISIBLE     1 1
+   9 JUMP        -> 11
+  10 STEP
+  11 CONST       "A"
+  12 LOAD        X
+  13 ADD
+  14 LOAD        IT
+  15 VISIBLE     2 1
+`
+	if s := f.String(); s != expected {
+		t.Errorf("Disassembled as\n%s", s)
+	}
+}